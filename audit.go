@@ -0,0 +1,199 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AuditRecord describes a single command invocation. Arguments which have been
+// marked as sensitive using Server.SetSensitiveArgs are redacted from Line and
+// Args before the record is handed to the Auditor.
+type AuditRecord struct {
+	SessionID uint64        `json:"session"`
+	Identity  string        `json:"identity,omitempty"`
+	Remote    string        `json:"remote"`
+	Line      string        `json:"line"`
+	Args      []string      `json:"args"`
+	Start     time.Time     `json:"start"`
+	Duration  time.Duration `json:"duration_ns"`
+	Quit      bool          `json:"quit"`
+	Error     string        `json:"error,omitempty"`
+	Cancelled bool          `json:"cancelled"`
+}
+
+// The Auditor interface is used to report every command run by any client of
+// a server. Audit is called after the command has finished and may be called
+// concurrently from several client sessions. Errors returned by Audit are
+// logged.
+type Auditor interface {
+	Audit(r *AuditRecord) error
+}
+
+// SetAuditor sets the auditor which will be informed about every command
+// executed by clients of this server. Set it to nil to disable auditing.
+func (s *Server) SetAuditor(a Auditor) {
+	s.auditor = a
+}
+
+// SetSensitiveArgs marks arguments of the command cmd as sensitive. The
+// indexes are positions inside the argument slice as passed to the command
+// function, which means index 0 is the command name itself. Sensitive
+// arguments will be redacted from all audit records. Once any sensitive
+// arguments have been set, all arguments of command lines which can't be
+// parsed are redacted.
+func (s *Server) SetSensitiveArgs(cmd string, idx ...int) {
+	if s.sensitive == nil {
+		s.sensitive = make(map[string][]int)
+	}
+	s.sensitive[cmd] = idx
+}
+
+const redacted = "***"
+
+func (s *Server) redactArgs(line string, args []string) (string, []string) {
+	if len(args) == 0 {
+		// the line couldn't be parsed so there is no telling which command
+		// it is meant for nor where its arguments are, everything but the
+		// first word goes
+		if fields := strings.Fields(line); len(fields) > 1 && len(s.sensitive) > 0 {
+			return fields[0] + " " + redacted, args
+		}
+		return line, args
+	}
+	idx, found := s.sensitive[args[0]]
+	if !found {
+		return line, args
+	}
+	r := make([]string, len(args))
+	copy(r, args)
+	changed := false
+	for _, i := range idx {
+		if i >= 0 && i < len(r) {
+			r[i] = redacted
+			changed = true
+		}
+	}
+	if !changed {
+		return line, args
+	}
+	quoted := make([]string, len(r))
+	for i, arg := range r {
+		if arg == redacted {
+			quoted[i] = arg
+		} else {
+			quoted[i] = QuoteArgument(arg)
+		}
+	}
+	return strings.Join(quoted, " "), r
+}
+
+// redactLine redacts sensitive arguments from a command line as typed by a
+// user.
+func (s *Server) redactLine(line string) string {
+	args, err := splitCmdArguments(line)
+	if err != nil {
+		args = nil
+	}
+	line, _ = s.redactArgs(line, args)
+	return line
+}
+
+func (c *Client) audit(line string, args []string, start time.Time, quit bool, err error) {
+	if c.srv.auditor == nil {
+		return
+	}
+	r := &AuditRecord{
+		SessionID: c.id,
+		Identity:  c.Identity,
+		Remote:    c.Conn.RemoteAddr().String(),
+		Start:     start,
+		Duration:  time.Since(start),
+		Quit:      quit,
+		Cancelled: atomic.LoadInt32(&c.cancelled) != 0,
+	}
+	r.Line, r.Args = c.srv.redactArgs(line, args)
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if err := c.srv.auditor.Audit(r); err != nil {
+		c.log.Error("audit failed", "error", err)
+	}
+}
+
+// JSONAuditor is an Auditor which writes every record as a single line of
+// JSON to an io.Writer.
+type JSONAuditor struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONAuditor creates an auditor writing JSON lines to w.
+func NewJSONAuditor(w io.Writer) *JSONAuditor {
+	return &JSONAuditor{w: w}
+}
+
+// OpenJSONAuditor creates an auditor which appends JSON lines to the file
+// at path. The file will be created if it doesn't exist.
+func OpenJSONAuditor(path string) (*JSONAuditor, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONAuditor(f), nil
+}
+
+// Audit implements the Auditor interface.
+func (a *JSONAuditor) Audit(r *AuditRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.w.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the underlying writer if it implements io.Closer.
+func (a *JSONAuditor) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if cl, ok := a.w.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/spreadspace/telgo"
+	"github.com/spreadspace/telgo/telgotest"
+)
+
+type memAuditor struct {
+	mu      sync.Mutex
+	records []*telgo.AuditRecord
+}
+
+func (a *memAuditor) Audit(r *telgo.AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records = append(a.records, r)
+	return nil
+}
+
+func (a *memAuditor) last() *telgo.AuditRecord {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.records) == 0 {
+		return nil
+	}
+	return a.records[len(a.records)-1]
+}
+
+func TestAuditRedaction(t *testing.T) {
+	cmds := telgo.CmdList{
+		"login": func(c *telgo.Client, args []string) bool { return false },
+		"echo":  func(c *telgo.Client, args []string) bool { return false },
+		"wait": func(c *telgo.Client, args []string) bool {
+			c.Sayln("waiting")
+			<-c.Context().Done()
+			return false
+		},
+		"fail": func(c *telgo.Client, args []string) bool {
+			c.Errorf("boom")
+			return false
+		},
+	}
+	a := &memAuditor{}
+	h := telgotest.New(t, "> ", cmds, nil)
+	h.Server.SetAuditor(a)
+	h.Server.SetSensitiveArgs("login", 2)
+	s := h.Connect()
+	s.ExpectPrompt()
+
+	for _, tt := range []struct {
+		line  string
+		want  string
+		args  []string
+		error bool
+	}{
+		{`login alice secret`, `login alice ***`, []string{"login", "alice", "***"}, false},
+		{`login "alice smith" "top secret"`, `login "alice smith" ***`, []string{"login", "alice smith", "***"}, false},
+		{`login "a\"b" secret`, `login "a\"b" ***`, []string{"login", `a"b`, "***"}, false},
+		{`login alice`, `login alice`, []string{"login", "alice"}, false},
+		{`login "alice secret`, `login ***`, nil, true},
+		{`login alice "secret`, `login ***`, nil, true},
+		{`"login" alice "secret`, `"login" ***`, nil, true},
+		{`echo "a b`, `echo ***`, nil, true},
+		{`echo "a b" c`, `echo "a b" c`, []string{"echo", "a b", "c"}, false},
+		{`fail now`, `fail now`, []string{"fail", "now"}, true},
+	} {
+		s.Run(tt.line)
+		r := a.last()
+		if r == nil {
+			t.Fatalf("%s: no audit record", tt.line)
+		}
+		if r.Line != tt.want {
+			t.Errorf("%s: expected line %q, got %q", tt.line, tt.want, r.Line)
+		}
+		if !reflect.DeepEqual(r.Args, tt.args) {
+			t.Errorf("%s: expected args %q, got %q", tt.line, tt.args, r.Args)
+		}
+		if (r.Error != "") != tt.error {
+			t.Errorf("%s: unexpected error %q", tt.line, r.Error)
+		}
+	}
+
+	s.Type("wait")
+	s.Expect("waiting\r\n")
+	s.CtrlC()
+	s.ExpectPrompt()
+	if r := a.last(); r == nil || !r.Cancelled {
+		t.Errorf("expected a cancelled record, got %+v", r)
+	}
+	s.Run(`login "alice`)
+	if r := a.last(); r == nil || r.Cancelled {
+		t.Errorf("the record of an unparsable line after a cancelled command must not be cancelled, got %+v", r)
+	}
+}
@@ -0,0 +1,445 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+// Package client implements a client for telgo servers which can be used by
+// tests and tools. It dials a server via TCP, TLS or unix sockets, handles
+// the telnet option negotiation, waits for the prompt and runs commands.
+// Commands are either run as the user would type them, in which case the
+// output is everything the server sends until the next prompt shows up, or
+// in machine mode which returns the output and the exit status of every
+// command as structured data, see telgo.MachineCmd.
+//
+// The client refuses all telnet options except TERMINAL-TYPE, for which it
+// reports the terminal type "dumb". This way the server won't send any
+// colors or in-place redraws.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spreadspace/telgo"
+)
+
+const (
+	bIP   = byte(244)
+	bSB   = byte(250)
+	bWILL = byte(251)
+	bWONT = byte(252)
+	bDO   = byte(253)
+	bDONT = byte(254)
+	bIAC  = byte(255)
+	bSE   = byte(240)
+
+	optTTYPE  = byte(24)
+	ttypeIS   = byte(0)
+	ttypeSEND = byte(1)
+
+	terminalType = "dumb"
+)
+
+// The time to wait for the server after sending the interrupt process
+// command when a context got canceled, and the time to wait for the first
+// prompt.
+const (
+	InterruptTimeout = 5 * time.Second
+	GreetTimeout     = 10 * time.Second
+)
+
+var (
+	// ErrMachineMode is returned by Run if the session is in machine mode.
+	ErrMachineMode = errors.New("session is in machine mode")
+	// ErrTextMode is returned by Exec if the session is not in machine mode.
+	ErrTextMode = errors.New("session is not in machine mode")
+)
+
+// Client is a connection to a telgo server. Apart from Interrupt and Close
+// the methods of a Client must not be called concurrently.
+type Client struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	wmu      sync.Mutex
+	prompt   string
+	greeting string
+	timeout  time.Duration
+	machine  bool
+	ttype    bool
+	nextID   uint64
+}
+
+// Dial connects to the telgo server at addr. network may be any network
+// supported by net.Dial, most likely "tcp" or "unix". See New for the meaning
+// of prompt.
+func Dial(network, addr, prompt string) (*Client, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return New(conn, prompt)
+}
+
+// DialTLS connects to the telgo server at addr using TLS.
+func DialTLS(network, addr, prompt string, config *tls.Config) (*Client, error) {
+	conn, err := tls.Dial(network, addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return New(conn, prompt)
+}
+
+// New creates a client using an already established connection and waits
+// for the first prompt. Everything the server sent before the prompt, for
+// example the output of the greeter, is available using Greeting. If prompt
+// is empty the server is expected to start the session in machine mode, see
+// telgo.Server.SetMachineMode. The connection is closed if the server doesn't
+// show the prompt within GreetTimeout.
+func New(conn net.Conn, prompt string) (*Client, error) {
+	c := &Client{conn: conn, r: bufio.NewReader(conn), prompt: prompt}
+	if prompt == "" { // there is no telnet negotiation in machine mode
+		c.machine = true
+		return c, nil
+	}
+	c.ttype = true
+	if err := c.write([]byte{bIAC, bWILL, optTTYPE}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(GreetTimeout))
+	greeting, err := c.readPrompt()
+	if err == nil {
+		// an empty line makes sure the option negotiation is done before
+		// the first command is sent
+		if err = c.writeLine(""); err == nil {
+			_, err = c.readPrompt()
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+	c.greeting = greeting
+	return c, nil
+}
+
+// Greeting returns the output the server sent before the first prompt.
+func (c *Client) Greeting() string {
+	return c.greeting
+}
+
+// SetTimeout sets the maximum time Run and Exec wait for a command to
+// finish. Commands which take longer get interrupted as if the context had
+// been canceled. A timeout of 0, which is the default, means no timeout.
+func (c *Client) SetTimeout(d time.Duration) {
+	c.timeout = d
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Interrupt sends the telnet interrupt process command which cancels the
+// running command, just like hitting Ctrl-C does.
+func (c *Client) Interrupt() error {
+	return c.write([]byte{bIAC, bIP})
+}
+
+func (c *Client) write(data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.conn.Write(data)
+	return err
+}
+
+func (c *Client) writeLine(line string) error {
+	data := bytes.Replace([]byte(line), []byte{bIAC}, []byte{bIAC, bIAC}, -1)
+	return c.write(append(data, '\r', '\n'))
+}
+
+// readByte returns the next byte of data sent by the server and handles
+// all telnet commands on the way.
+func (c *Client) readByte() (byte, error) {
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil || b != bIAC {
+			return b, err
+		}
+		cmd, err := c.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch cmd {
+		case bIAC:
+			return bIAC, nil
+		case bDO, bDONT, bWILL, bWONT:
+			opt, err := c.r.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			if err := c.negotiate(cmd, opt); err != nil {
+				return 0, err
+			}
+		case bSB:
+			data, err := c.readSubnegotiation()
+			if err != nil {
+				return 0, err
+			}
+			if len(data) == 2 && data[0] == optTTYPE && data[1] == ttypeSEND {
+				reply := append([]byte{bIAC, bSB, optTTYPE, ttypeIS}, terminalType...)
+				if err := c.write(append(reply, bIAC, bSE)); err != nil {
+					return 0, err
+				}
+			}
+		}
+		// all other commands (NOP, GA, ...) are ignored
+	}
+}
+
+func (c *Client) readSubnegotiation() ([]byte, error) {
+	var data []byte
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == bIAC {
+			if b, err = c.r.ReadByte(); err != nil {
+				return nil, err
+			}
+			if b == bSE {
+				return data, nil
+			}
+		}
+		data = append(data, b)
+	}
+}
+
+func (c *Client) negotiate(cmd, opt byte) error {
+	switch cmd {
+	case bDO:
+		if opt == optTTYPE {
+			if c.ttype {
+				return nil
+			}
+			c.ttype = true
+			return c.write([]byte{bIAC, bWILL, opt})
+		}
+		return c.write([]byte{bIAC, bWONT, opt})
+	case bDONT:
+		if opt == optTTYPE && c.ttype {
+			c.ttype = false
+			return c.write([]byte{bIAC, bWONT, opt})
+		}
+	case bWILL:
+		return c.write([]byte{bIAC, bDONT, opt})
+	}
+	return nil
+}
+
+// readPrompt reads until the prompt shows up and returns everything before
+// it. The prompt is only recognized if it is the last thing the server sent,
+// apart from telnet commands.
+func (c *Client) readPrompt() (string, error) {
+	var buf []byte
+	for {
+		b, err := c.readByte()
+		if err != nil {
+			return normalize(buf), err
+		}
+		buf = append(buf, b)
+		if !bytes.HasSuffix(buf, []byte(c.prompt)) {
+			continue
+		}
+		if c.r.Buffered() > 0 {
+			if next, _ := c.r.Peek(1); next[0] != bIAC {
+				continue
+			}
+		}
+		return normalize(buf[:len(buf)-len(c.prompt)]), nil
+	}
+}
+
+func (c *Client) readLine() (string, error) {
+	var buf []byte
+	for {
+		b, err := c.readByte()
+		if err != nil {
+			return "", err
+		}
+		if b == '\n' {
+			return strings.TrimSuffix(string(buf), "\r"), nil
+		}
+		buf = append(buf, b)
+	}
+}
+
+func normalize(data []byte) string {
+	return string(bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1))
+}
+
+// watch applies the timeout and interrupts the running command if ctx gets
+// canceled. The returned function must be called once the command is done,
+// it returns the error of the context if the command got interrupted.
+func (c *Client) watch(ctx context.Context) func() error {
+	cancel := func() {}
+	if c.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+	}
+	done := make(chan bool)
+	stopped := make(chan bool)
+	go func() {
+		defer close(stopped)
+		select {
+		case <-done:
+		case <-ctx.Done():
+			c.Interrupt()
+			c.conn.SetReadDeadline(time.Now().Add(InterruptTimeout))
+		}
+	}()
+	return func() error {
+		close(done)
+		<-stopped
+		err := ctx.Err()
+		cancel()
+		c.conn.SetReadDeadline(time.Time{})
+		return err
+	}
+}
+
+// Run runs the command line and returns its output, see RunContext.
+func (c *Client) Run(line string) (string, error) {
+	return c.RunContext(context.Background(), line)
+}
+
+// RunContext sends the command line to the server and returns everything
+// the server sent until the next prompt with new-lines converted to "\n".
+// If ctx gets canceled or the timeout expires the command is interrupted and
+// its output is returned together with the error of the context.
+func (c *Client) RunContext(ctx context.Context, line string) (string, error) {
+	if c.machine {
+		return "", ErrMachineMode
+	}
+	if err := c.writeLine(line); err != nil {
+		return "", err
+	}
+	stop := c.watch(ctx)
+	out, err := c.readPrompt()
+	if cerr := stop(); cerr != nil {
+		return out, cerr
+	}
+	return out, err
+}
+
+// EnterMachineMode switches the session into machine mode by running cmd,
+// which should be the name the server uses for telgo.MachineCmd. Afterwards
+// commands must be run using Exec.
+func (c *Client) EnterMachineMode(cmd string) error {
+	if c.machine {
+		return nil
+	}
+	if err := c.writeLine(cmd); err != nil {
+		return err
+	}
+	stop := c.watch(context.Background())
+	defer stop()
+
+	var buf []byte
+	for {
+		b, err := c.readByte()
+		if err != nil {
+			return err
+		}
+		buf = append(buf, b)
+		if b == '\n' {
+			if strings.TrimSpace(string(buf)) == telgo.MachineHello {
+				c.machine = true
+				return nil
+			}
+		} else if bytes.HasSuffix(buf, []byte(c.prompt)) {
+			return fmt.Errorf("server doesn't support machine mode: %s", strings.TrimSpace(normalize(buf[:len(buf)-len(c.prompt)])))
+		}
+	}
+}
+
+// Exec runs the command cmd with the arguments args in machine mode. The
+// arguments are passed to the command as they are and don't need any
+// quoting. An error is only returned if the communication with the server
+// failed, use the Status and Error fields of the response to find out
+// whether the command succeeded. If ctx gets canceled or the timeout expires
+// the command is interrupted and the response will have the status
+// telgo.StatusCancelled.
+func (c *Client) Exec(ctx context.Context, cmd string, args ...string) (*telgo.MachineResponse, error) {
+	if !c.machine {
+		return nil, ErrTextMode
+	}
+	c.nextID++
+	id := strconv.FormatUint(c.nextID, 10)
+	req, err := json.Marshal(&telgo.MachineRequest{ID: json.RawMessage(id), Cmd: cmd, Args: args})
+	if err != nil {
+		return nil, err
+	}
+	if err := c.writeLine(string(req)); err != nil {
+		return nil, err
+	}
+	stop := c.watch(ctx)
+	resp, err := c.readResponse(id)
+	if cerr := stop(); cerr != nil && err != nil {
+		return nil, cerr
+	}
+	return resp, err
+}
+
+func (c *Client) readResponse(id string) (*telgo.MachineResponse, error) {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		var resp telgo.MachineResponse
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			return nil, fmt.Errorf("invalid response from server: %v", err)
+		}
+		if string(resp.ID) == id {
+			return &resp, nil
+		}
+		// response to an earlier request which got lost, skip it
+	}
+}
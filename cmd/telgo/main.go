@@ -0,0 +1,336 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+// Command telgo is a client for telgo servers meant for scripting. It runs
+// a single command given on the command line, all commands of a script file
+// or, if neither is given and stdin is a terminal, offers an interactive
+// session with local line editing and history.
+//
+// Usage:
+//
+//	telgo [options] address [command [argument ...]]
+//
+// The address is either host:port or the path of a unix socket. Commands
+// and scripts are run in machine mode, see telgo.MachineCmd, so the exit
+// code of telgo is the exit status of the command which failed. Exit code 2
+// means telgo itself failed, for example because the server can't be
+// reached. Script files contain one command per line, empty lines and lines
+// starting with # are ignored. The script stops at the first failing command
+// unless -k is given. In text mode, see -text, telgo can only tell from the
+// output of a command whether it failed, which works for commands reporting
+// errors using telgo.Client.Errorf as well as for unknown commands.
+//
+// Hitting Ctrl-C while a command is running interrupts the command, at the
+// prompt of an interactive session it terminates telgo just like Ctrl-D.
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+
+	"github.com/spreadspace/telgo"
+	"github.com/spreadspace/telgo/client"
+	"golang.org/x/term"
+)
+
+const exitFailure = 2
+
+var (
+	prompt     = flag.String("prompt", "> ", "the prompt of the server, use an empty prompt for servers which start sessions in machine mode")
+	useTLS     = flag.Bool("tls", false, "connect using TLS")
+	insecure   = flag.Bool("insecure", false, "don't verify the certificate of the server")
+	script     = flag.String("f", "", "run the commands of this script file, - reads them from stdin")
+	jsonOutput = flag.Bool("json", false, "print the responses of the server as JSON")
+	textMode   = flag.Bool("text", false, "don't use machine mode, print the output just like the server sends it")
+	machineCmd = flag.String("machine", "machine", "the name of the command which switches the session into machine mode")
+	keepGoing  = flag.Bool("k", false, "don't stop the script at the first failing command")
+	timeout    = flag.Duration("timeout", 0, "interrupt commands which take longer than this")
+)
+
+func fatalf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "telgo: "+format+"\n", a...)
+	os.Exit(exitFailure)
+}
+
+func dial(addr string) (*client.Client, error) {
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix:") {
+		network, addr = "unix", strings.TrimPrefix(addr, "unix:")
+	} else if strings.Contains(addr, "/") {
+		network = "unix"
+	}
+	if *useTLS {
+		return client.DialTLS(network, addr, *prompt, &tls.Config{InsecureSkipVerify: *insecure})
+	}
+	return client.Dial(network, addr, *prompt)
+}
+
+// runner runs a single command line and returns its exit status
+type runner func(ctx context.Context, line string) (int, error)
+
+func textRunner(cl *client.Client) runner {
+	return func(ctx context.Context, line string) (int, error) {
+		out, err := cl.RunContext(ctx, line)
+		fmt.Print(out)
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return telgo.StatusCancelled, nil
+		}
+		return textStatus(out), err
+	}
+}
+
+// textStatus guesses the exit status of a command from the messages the
+// server prints for failed commands.
+func textStatus(out string) int {
+	for _, l := range strings.Split(out, "\n") {
+		if strings.HasPrefix(l, "error: ") || strings.HasPrefix(l, "unknown command '") || strings.HasPrefix(l, "can't parse command: ") {
+			return telgo.StatusError
+		}
+	}
+	return telgo.StatusOK
+}
+
+func machineRunner(cl *client.Client) runner {
+	enc := json.NewEncoder(os.Stdout)
+	return func(ctx context.Context, line string) (int, error) {
+		args, err := telgo.SplitArguments(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "telgo: can't parse command: %v\n", err)
+			return telgo.StatusError, nil
+		}
+		if len(args) == 0 {
+			return telgo.StatusOK, nil
+		}
+		resp, err := cl.Exec(ctx, args[0], args[1:]...)
+		if err != nil {
+			return 0, err
+		}
+		if *jsonOutput {
+			return resp.Status, enc.Encode(resp)
+		}
+		for _, l := range resp.Output {
+			fmt.Println(l)
+		}
+		if resp.Error != "" {
+			fmt.Fprintf(os.Stderr, "telgo: %s\n", resp.Error)
+		}
+		return resp.Status, nil
+	}
+}
+
+func readScript(path string) ([]string, error) {
+	r := io.Reader(os.Stdin)
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// runLines runs all lines and returns the exit code for telgo
+func runLines(run runner, lines []string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	code := 0
+	for _, line := range lines {
+		status, err := run(ctx, line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "telgo: %v\n", err)
+			return exitFailure
+		}
+		if status != telgo.StatusOK {
+			code = status
+			if !*keepGoing || status == telgo.StatusCancelled {
+				break
+			}
+		}
+	}
+	return code
+}
+
+// input passes the keys typed by the user on to the line editor. While a
+// command is running Ctrl-C interrupts it and everything else is dropped.
+type input struct {
+	cl   *client.Client
+	busy int32
+	data chan []byte
+	rest []byte
+}
+
+func (in *input) pump() {
+	defer close(in.data)
+	buf := make([]byte, 256)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		if atomic.LoadInt32(&in.busy) != 0 {
+			if strings.IndexByte(string(buf[:n]), 3) >= 0 {
+				in.cl.Interrupt()
+			}
+			continue
+		}
+		in.data <- append([]byte(nil), buf[:n]...)
+	}
+}
+
+func (in *input) Read(p []byte) (int, error) {
+	if len(in.rest) == 0 {
+		data, ok := <-in.data
+		if !ok {
+			return 0, io.EOF
+		}
+		in.rest = data
+	}
+	n := copy(p, in.rest)
+	in.rest = in.rest[n:]
+	return n, nil
+}
+
+func interactive(cl *client.Client) int {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		fatalf("can't switch terminal to raw mode: %v", err)
+	}
+	defer term.Restore(fd, state)
+
+	in := &input{cl: cl, data: make(chan []byte)}
+	go in.pump()
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{in, os.Stdout}, *prompt)
+	if w, h, err := term.GetSize(fd); err == nil && w > 0 && h > 0 {
+		t.SetSize(w, h)
+	}
+	t.Write([]byte(cl.Greeting()))
+	for {
+		line, err := t.ReadLine()
+		if err != nil {
+			return 0
+		}
+		atomic.StoreInt32(&in.busy, 1)
+		out, err := cl.Run(line)
+		atomic.StoreInt32(&in.busy, 0)
+		t.Write([]byte(out))
+		if err == io.EOF {
+			return 0 // the server closed the session
+		}
+		if err != nil {
+			t.Write([]byte(fmt.Sprintf("telgo: %v\n", err)))
+			return exitFailure
+		}
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] address [command [argument ...]]\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(exitFailure)
+	}
+	if *jsonOutput && *textMode {
+		fatalf("-json can't be used together with -text")
+	}
+
+	var lines []string
+	session := false
+	if flag.NArg() > 1 {
+		quoted := make([]string, flag.NArg()-1)
+		for i, arg := range flag.Args()[1:] {
+			quoted[i] = telgo.QuoteArgument(arg)
+		}
+		lines = []string{strings.Join(quoted, " ")}
+	} else if *script != "" {
+		var err error
+		if lines, err = readScript(*script); err != nil {
+			fatalf("can't read script: %v", err)
+		}
+	} else if !term.IsTerminal(int(os.Stdin.Fd())) {
+		fatalf("no command given and stdin is not a terminal")
+	} else {
+		session = true
+	}
+
+	cl, err := dial(flag.Arg(0))
+	if err != nil {
+		fatalf("can't connect: %v", err)
+	}
+	cl.SetTimeout(*timeout)
+
+	if session {
+		if *prompt == "" {
+			fatalf("interactive sessions need a prompt")
+		}
+		code := interactive(cl)
+		cl.Close()
+		os.Exit(code)
+	}
+
+	run := textRunner(cl)
+	if !*textMode {
+		if err := cl.EnterMachineMode(*machineCmd); err != nil {
+			fatalf("%v (use -text for servers without machine mode)", err)
+		}
+		run = machineRunner(cl)
+	}
+	code := runLines(run, lines)
+	cl.Close()
+	os.Exit(code)
+}
@@ -0,0 +1,90 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+// Package cobracmd mounts command trees built with github.com/spf13/cobra on
+// a telgo server. The input and output streams of the cobra commands are
+// connected to the client and the context passed to the commands gets
+// canceled when the user hits Ctrl-C.
+//
+// Since cobra commands keep the values of their flags inside the command
+// structs, the tree is created anew for every invocation. This makes it safe
+// to run the commands concurrently from several sessions.
+package cobracmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spreadspace/telgo"
+)
+
+// Cmd creates a telgo command which runs the command tree returned by
+// newRoot. The arguments of the telgo command, without the command name
+// itself, are passed to the root command.
+func Cmd(newRoot func() *cobra.Command) telgo.Cmd {
+	return func(c *telgo.Client, args []string) bool {
+		return run(c, newRoot(), args[1:])
+	}
+}
+
+// Mount adds a telgo command for every sub command (including aliases) of
+// the root command returned by newRoot. This way the sub commands become top
+// level telgo commands.
+func Mount(cmds telgo.CmdList, newRoot func() *cobra.Command) {
+	root := prepare(newRoot())
+	root.InitDefaultHelpCmd()
+	for _, sub := range root.Commands() {
+		if sub.Hidden {
+			continue
+		}
+		cmd := func(c *telgo.Client, args []string) bool {
+			return run(c, newRoot(), args)
+		}
+		cmds[sub.Name()] = cmd
+		for _, alias := range sub.Aliases {
+			cmds[alias] = cmd
+		}
+	}
+}
+
+func prepare(root *cobra.Command) *cobra.Command {
+	root.CompletionOptions.DisableDefaultCmd = true
+	return root
+}
+
+func run(c *telgo.Client, root *cobra.Command, args []string) bool {
+	prepare(root)
+	root.SetArgs(args)
+	root.SetIn(c.Stdin())
+	root.SetOut(c)
+	root.SetErr(c)
+	root.SilenceErrors = true // reported using Errorf instead
+	if err := root.ExecuteContext(c.Context()); err != nil {
+		c.Errorf("%v", err)
+	}
+	return false
+}
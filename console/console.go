@@ -0,0 +1,126 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+// Package console runs telgo client sessions on local terminals. Editor
+// provides the line editing for telgo.StreamConn, which is used by Run as
+// well as by the sshd package for sessions with a pseudo terminal. This
+// way the core package doesn't depend on golang.org/x/term.
+package console
+
+import (
+	"io"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/spreadspace/telgo"
+	"golang.org/x/term"
+)
+
+const resizeInterval = time.Second
+
+type consoleAddr struct{}
+
+func (consoleAddr) Network() string { return "console" }
+func (consoleAddr) String() string  { return "console" }
+
+// Editor creates a line editor using term.Terminal which offers the usual
+// line editing keys and a history. Ctrl-D on an empty line ends the input.
+// It is meant to be passed to telgo.NewStreamConn.
+func Editor(keys io.Reader, out io.Writer) telgo.LineEditor {
+	return term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{keys, out}, "")
+}
+
+// watchConsoleSize reports changes of the terminal size until done is closed
+func watchConsoleSize(fd int, conn *telgo.StreamConn, done chan bool) {
+	t := time.NewTicker(resizeInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		if w, h, err := term.GetSize(fd); err == nil {
+			conn.SetWindowSize(w, h)
+		}
+	}
+}
+
+// Run runs a client session of s on the terminal the process has been
+// started from. This way the commands of the server can also be used while
+// running in the foreground, for example during development. The terminal is
+// switched into raw mode and lines are edited locally with the usual line
+// editing keys and a history, Ctrl-C cancels the running command and Ctrl-D
+// on an empty line ends the session. The identity of the session is set to
+// the name of the user running the process. If stdin is not a terminal
+// telgo.Server.RunStdio is used instead. Run returns when the session is
+// over, run it alongside telgo.Server.Run to accept network sessions at the
+// same time. The optional parameters are the same as for telgo.Server.Run.
+// Log messages written to the terminal while the session is running will
+// mess up the screen.
+func Run(s *telgo.Server, params ...interface{}) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		s.RunStdio(params...)
+		return nil
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+
+	conn := telgo.NewStreamConn(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, consoleAddr{}, Editor)
+	ttype := os.Getenv("TERM")
+	if ttype == "" {
+		ttype = "unknown"
+	}
+	conn.SetTerminalType(ttype)
+	if w, h, err := term.GetSize(fd); err == nil {
+		conn.SetWindowSize(w, h)
+	}
+	done := make(chan bool)
+	defer close(done)
+	go watchConsoleSize(fd, conn, done)
+
+	setup := func(c *telgo.Client) {
+		if u, err := user.Current(); err == nil {
+			c.Identity = u.Username
+		}
+	}
+	s.ServeConn(conn, append([]interface{}{setup}, params...)...)
+	return nil
+}
@@ -0,0 +1,204 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+// Package diag contains a set of telgo commands which can be used to inspect
+// the runtime state of the application. Use Register to add all of them to a
+// command list or pick individual commands.
+package diag
+
+import (
+	"expvar"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spreadspace/telgo"
+)
+
+var (
+	started = time.Now()
+)
+
+// Register adds all commands of this package to cmds using their default
+// names: goroutines, memstats, gc, buildinfo, uptime, gomaxprocs and expvar.
+func Register(cmds telgo.CmdList) {
+	cmds["goroutines"] = Goroutines
+	cmds["memstats"] = MemStats
+	cmds["gc"] = GC
+	cmds["buildinfo"] = BuildInfo
+	cmds["uptime"] = Uptime
+	cmds["gomaxprocs"] = GoMaxProcs
+	cmds["expvar"] = Expvar
+}
+
+// Goroutines prints the number of goroutines and a stack dump of all of them.
+func Goroutines(c *telgo.Client, args []string) bool {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	c.Sayln("%d goroutines", runtime.NumGoroutine())
+	c.Sayln("")
+	c.Write(buf)
+	return false
+}
+
+func sayBytes(c *telgo.Client, name string, b uint64) {
+	c.Sayln("%-16s %12d (%.1f MiB)", name+":", b, float64(b)/(1024*1024))
+}
+
+// MemStats prints the most important values of runtime.MemStats.
+func MemStats(c *telgo.Client, args []string) bool {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	sayBytes(c, "Alloc", m.Alloc)
+	sayBytes(c, "TotalAlloc", m.TotalAlloc)
+	sayBytes(c, "Sys", m.Sys)
+	sayBytes(c, "HeapAlloc", m.HeapAlloc)
+	sayBytes(c, "HeapSys", m.HeapSys)
+	sayBytes(c, "HeapIdle", m.HeapIdle)
+	sayBytes(c, "HeapInuse", m.HeapInuse)
+	sayBytes(c, "HeapReleased", m.HeapReleased)
+	sayBytes(c, "StackInuse", m.StackInuse)
+	c.Sayln("%-16s %12d", "HeapObjects:", m.HeapObjects)
+	c.Sayln("%-16s %12d", "Mallocs:", m.Mallocs)
+	c.Sayln("%-16s %12d", "Frees:", m.Frees)
+	c.Sayln("%-16s %12d", "NumGC:", m.NumGC)
+	c.Sayln("%-16s %12d", "NumForcedGC:", m.NumForcedGC)
+	c.Sayln("%-16s %12s", "PauseTotal:", time.Duration(m.PauseTotalNs))
+	if m.NumGC > 0 {
+		last := time.Unix(0, int64(m.LastGC))
+		c.Sayln("%-16s %12s (%s ago)", "LastPause:", time.Duration(m.PauseNs[(m.NumGC+255)%256]), time.Since(last).Truncate(time.Millisecond))
+	}
+	c.Sayln("%-16s %12.6f", "GCCPUFraction:", m.GCCPUFraction)
+	c.Sayln("%-16s %12d", "NextGC:", m.NextGC)
+	return false
+}
+
+// GC forces a garbage collection and prints the heap size before and after.
+func GC(c *telgo.Client, args []string) bool {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	runtime.GC()
+	d := time.Since(start)
+	runtime.ReadMemStats(&after)
+	c.Sayln("garbage collection took %s", d)
+	sayBytes(c, "HeapAlloc before", before.HeapAlloc)
+	sayBytes(c, "HeapAlloc after", after.HeapAlloc)
+	return false
+}
+
+// BuildInfo prints the build information embedded into the binary.
+func BuildInfo(c *telgo.Client, args []string) bool {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		c.Sayln("no build information available")
+		return false
+	}
+	c.Sayln("%-12s %s", "go:", bi.GoVersion)
+	c.Sayln("%-12s %s", "path:", bi.Path)
+	c.Sayln("%-12s %s %s", "main:", bi.Main.Path, bi.Main.Version)
+	for _, s := range bi.Settings {
+		c.Sayln("%-12s %s=%s", "setting:", s.Key, s.Value)
+	}
+	for _, d := range bi.Deps {
+		if d.Replace != nil {
+			c.Sayln("%-12s %s %s => %s %s", "dep:", d.Path, d.Version, d.Replace.Path, d.Replace.Version)
+		} else {
+			c.Sayln("%-12s %s %s", "dep:", d.Path, d.Version)
+		}
+	}
+	return false
+}
+
+// Uptime prints the time since the application has been started. This is
+// actually the time since the initialization of this package which should be
+// close enough.
+func Uptime(c *telgo.Client, args []string) bool {
+	c.Sayln("up %s (since %s)", time.Since(started).Truncate(time.Second), started.Format(time.RFC3339))
+	return false
+}
+
+// GoMaxProcs prints the current value of GOMAXPROCS. If a value is supplied
+// as argument GOMAXPROCS will be set to this value.
+func GoMaxProcs(c *telgo.Client, args []string) bool {
+	switch len(args) {
+	case 1:
+		c.Sayln("GOMAXPROCS: %d (NumCPU: %d)", runtime.GOMAXPROCS(0), runtime.NumCPU())
+	case 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			c.Errorf("'%s' is not a valid value: must be a positive integer", args[1])
+			return false
+		}
+		old := runtime.GOMAXPROCS(n)
+		c.Sayln("GOMAXPROCS: %d -> %d", old, n)
+	default:
+		c.Errorf("usage: %s [<n>]", args[0])
+	}
+	return false
+}
+
+// Expvar prints all published expvar variables. If arguments are supplied
+// only variables whose names start with one of the arguments are shown.
+func Expvar(c *telgo.Client, args []string) bool {
+	var names []string
+	vars := make(map[string]expvar.Var)
+	expvar.Do(func(kv expvar.KeyValue) {
+		if len(args) > 1 {
+			found := false
+			for _, prefix := range args[1:] {
+				if strings.HasPrefix(kv.Key, prefix) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return
+			}
+		}
+		names = append(names, kv.Key)
+		vars[kv.Key] = kv.Value
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		c.Sayln("%s: %s", name, vars[name].String())
+	}
+	return false
+}
@@ -39,35 +39,34 @@ import (
 )
 
 func echo(c *telgo.Client, args []string) bool {
-	c.Sayln(strings.Join(args[1:], " "))
+	c.Sayln("%s", strings.Join(args[1:], " "))
 	return false
 }
 
 func run(c *telgo.Client, args []string) bool {
 	if len(args) != 2 {
-		c.Sayln("usage: run <duration>")
+		c.Errorf("usage: run <duration>")
 		return false
 	}
 	var duration uint
 	d, err := strconv.ParseUint(args[1], 10, 32)
 	if err != nil {
-		c.Sayln("'%s' is not a vaild duration: must be a positive integer", args[1])
+		c.Errorf("'%s' is not a vaild duration: must be a positive integer", args[1])
 		return false
 	}
 	duration = uint(d)
 	c.Sayln("this will run for %d seconds (type Ctrl-C to abort)", duration)
-	c.Say("running ...   0.0%%\r")
+	p := c.Progress(int64(duration * 10)).SetLabel("running ...")
 	for i := uint(0); i < duration*10; i++ {
 		select {
 		case <-c.Cancel:
-			c.Sayln("\r\naborted.")
-			return false
+			return false // this also finishes the progress bar
 		default:
 		}
 		time.Sleep(100 * time.Millisecond)
-		c.Say("running ... %5.1f%%\r", (float64(i)/float64(duration*10))*100.0)
+		p.Add(1)
 	}
-	c.Sayln("running ... 100.0%% ... done.")
+	p.Done()
 	return false
 }
 
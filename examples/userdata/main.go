@@ -42,7 +42,7 @@ func whoami(c *telgo.Client, args []string, hostname string) bool {
 
 func setname(c *telgo.Client, args []string, hostname string) bool {
 	if len(args) != 2 {
-		c.Sayln("invalid number of arguments!")
+		c.Errorf("invalid number of arguments!")
 		return false
 	}
 	c.UserData = args[1]
@@ -0,0 +1,190 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+// Package flagcmd creates telgo commands which parse their arguments using
+// the flag package of the standard library. Every invocation works on its own
+// FlagSet so concurrent sessions don't share any state. The output of -h as
+// well as parse errors are sent to the client.
+package flagcmd
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/spreadspace/telgo"
+)
+
+// RunFunc is called after the arguments have been parsed successfully. fs
+// is the FlagSet of this invocation, use fs.Args() to get the remaining
+// arguments. The return value has the same meaning as for telgo.Cmd.
+type RunFunc func(c *telgo.Client, fs *flag.FlagSet) bool
+
+// Cloner must be implemented by flag values of custom types which are used
+// with New. Clone returns a new value set to the same value which doesn't
+// share any state with the original.
+type Cloner interface {
+	Clone() flag.Value
+}
+
+func parse(c *telgo.Client, fs *flag.FlagSet, args []string) bool {
+	var out bytes.Buffer
+	fs.SetOutput(&out)
+	err := fs.Parse(args[1:])
+	fs.SetOutput(c)
+	switch {
+	case err == flag.ErrHelp:
+		c.Say("%s", out.String())
+	case err != nil:
+		// the flag package prints the error followed by the usage
+		c.Errorf("%v", err)
+		c.Say("%s", strings.TrimPrefix(out.String(), err.Error()+"\n"))
+	}
+	return err == nil
+}
+
+// invoke parses the arguments and calls run. Panics caused by broken flag
+// values or the command itself are reported to the user instead of taking
+// down the whole server.
+func invoke(c *telgo.Client, args []string, fs func() (*flag.FlagSet, RunFunc)) (quit bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.Errorf("%s: panic: %v", args[0], r)
+			quit = false
+		}
+	}()
+	n, run := fs()
+	if !parse(c, n, args) {
+		return false
+	}
+	return run(c, n)
+}
+
+// copyFlag defines a flag with the same name, usage and value as f on fs.
+// Values of the standard library types get a fresh copy, custom types must
+// implement Cloner. ok is false if the value can't be copied.
+func copyFlag(fs *flag.FlagSet, f *flag.Flag) (ok bool) {
+	if cl, isCloner := f.Value.(Cloner); isCloner {
+		fs.Var(cl.Clone(), f.Name, f.Usage)
+		return true
+	}
+	t := reflect.TypeOf(f.Value)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.PkgPath() != "flag" {
+		return false
+	}
+	if t.Kind() == reflect.Func {
+		fs.Var(f.Value, f.Name, f.Usage) // flag.Func and flag.BoolFunc have no state
+		return true
+	}
+	g, isGetter := f.Value.(flag.Getter)
+	if !isGetter {
+		return false
+	}
+	switch v := g.Get().(type) {
+	case bool:
+		fs.Bool(f.Name, v, f.Usage)
+	case int:
+		fs.Int(f.Name, v, f.Usage)
+	case int64:
+		fs.Int64(f.Name, v, f.Usage)
+	case uint:
+		fs.Uint(f.Name, v, f.Usage)
+	case uint64:
+		fs.Uint64(f.Name, v, f.Usage)
+	case float64:
+		fs.Float64(f.Name, v, f.Usage)
+	case string:
+		fs.String(f.Name, v, f.Usage)
+	case time.Duration:
+		fs.Duration(f.Name, v, f.Usage)
+	default:
+		return false // flag.TextVar points to a variable of its own
+	}
+	return true
+}
+
+// New creates a telgo command from the flags defined in fs. For every
+// invocation the flags are copied to a new FlagSet which is then used to
+// parse the arguments. The values must be retrieved from this FlagSet, for
+// example using Lookup, since the variables bound to fs are never touched.
+// Flag values of custom types must implement Cloner, New panics if a flag
+// can't be copied. Use NewFunc for such flags instead.
+func New(fs *flag.FlagSet, run RunFunc) telgo.Cmd {
+	fs.VisitAll(func(f *flag.Flag) {
+		if !copyFlag(flag.NewFlagSet("", flag.ContinueOnError), f) {
+			panic(fmt.Sprintf("flagcmd.New(): value of flag -%s (%T) can't be copied, implement Cloner or use NewFunc", f.Name, f.Value))
+		}
+	})
+	return func(c *telgo.Client, args []string) bool {
+		return invoke(c, args, func() (*flag.FlagSet, RunFunc) {
+			n := flag.NewFlagSet(args[0], flag.ContinueOnError)
+			fs.VisitAll(func(f *flag.Flag) { copyFlag(n, f) })
+			return n, run
+		})
+	}
+}
+
+// NewFunc creates a telgo command which calls setup for every invocation to
+// define the flags on a fresh FlagSet. setup returns the function which will
+// be called after the arguments have been parsed. This makes it possible to
+// use variables bound to the flags:
+//
+//	flagcmd.NewFunc(func(fs *flag.FlagSet) flagcmd.RunFunc {
+//		verbose := fs.Bool("v", false, "be verbose")
+//		return func(c *telgo.Client, fs *flag.FlagSet) bool {
+//			...
+//		}
+//	})
+func NewFunc(setup func(fs *flag.FlagSet) RunFunc) telgo.Cmd {
+	return func(c *telgo.Client, args []string) bool {
+		return invoke(c, args, func() (*flag.FlagSet, RunFunc) {
+			fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+			return fs, setup(fs)
+		})
+	}
+}
+
+// Get returns the value of the flag name from fs. It returns nil if the flag
+// doesn't exist or its value doesn't implement the flag.Getter interface.
+func Get(fs *flag.FlagSet, name string) interface{} {
+	f := fs.Lookup(name)
+	if f == nil {
+		return nil
+	}
+	if g, ok := f.Value.(flag.Getter); ok {
+		return g.Get()
+	}
+	return nil
+}
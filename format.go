@@ -0,0 +1,75 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// SayColumns prints items in columns, like ls does, using as many columns as
+// fit into the width of the user's terminal. If it returns false the client
+// connection is about to be closed.
+func (c *Client) SayColumns(items []string) bool {
+	if len(items) == 0 {
+		return true
+	}
+	width, _ := c.WindowSize()
+	maxlen := 0
+	for _, item := range items {
+		if l := utf8.RuneCountInString(item); l > maxlen {
+			maxlen = l
+		}
+	}
+	colwidth := maxlen + 2
+	cols := width / colwidth
+	if cols < 1 {
+		cols = 1
+	}
+	rows := (len(items) + cols - 1) / cols
+
+	for r := 0; r < rows; r++ {
+		var line strings.Builder
+		for col := 0; col < cols; col++ {
+			i := col*rows + r
+			if i >= len(items) {
+				break
+			}
+			line.WriteString(items[i])
+			if col < cols-1 && i+rows < len(items) {
+				line.WriteString(strings.Repeat(" ", colwidth-utf8.RuneCountInString(items[i])))
+			}
+		}
+		if !c.Sayln("%s", line.String()) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,16 @@
+module github.com/spreadspace/telgo
+
+go 1.24.0
+
+require (
+	github.com/spf13/cobra v1.9.1
+	golang.org/x/crypto v0.42.0
+	golang.org/x/net v0.44.0
+	golang.org/x/term v0.35.0
+)
+
+require (
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/spf13/pflag v1.0.6 // indirect
+	golang.org/x/sys v0.36.0 // indirect
+)
@@ -0,0 +1,539 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+// Package inspect contains the telgo commands get, set and show which can be
+// used to inspect and modify configuration structures at runtime. The
+// commands walk the UserData of the client, or objects registered with the
+// Inspector, using dotted paths like "limits.max" or "servers.0.name".
+// Struct fields are matched case-insensitively and may be tagged using
+//
+//	`telgo:"name,readonly"`
+//
+// to change their name or protect them (and everything below them) from
+// being changed. Fields tagged with `telgo:"-"` as well as unexported fields
+// are invisible.
+package inspect
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spreadspace/telgo"
+)
+
+const (
+	maxDepth = 16
+)
+
+// MutexProvider may be implemented by inspected objects which need to be
+// locked while being read or modified. get and show will hold the read lock
+// and set will hold the write lock of the mutex. The lock is never held while
+// output is sent to the client.
+type MutexProvider interface {
+	Mutex() *sync.RWMutex
+}
+
+// Inspector holds a list of named objects which can be inspected.
+type Inspector struct {
+	mu      sync.RWMutex
+	objects map[string]interface{}
+}
+
+// New creates a new inspector. As long as no object has been added the
+// commands of the inspector operate on the UserData of the calling client.
+func New() *Inspector {
+	return &Inspector{objects: make(map[string]interface{})}
+}
+
+// Add registers obj under name. The first element of every path will then
+// be used to select the object. Only objects passed as pointer can be
+// modified using set.
+func (i *Inspector) Add(name string, obj interface{}) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.objects[name] = obj
+}
+
+// Register adds the commands of the inspector to cmds using their default
+// names: get, set and show.
+func (i *Inspector) Register(cmds telgo.CmdList) {
+	cmds["get"] = i.Get
+	cmds["set"] = i.Set
+	cmds["show"] = i.Show
+}
+
+// Register is a shortcut for New().Register(cmds).
+func Register(cmds telgo.CmdList) {
+	New().Register(cmds)
+}
+
+// root returns the object the path refers to as well as the rest of the path
+func (i *Inspector) root(c *telgo.Client, path []string) (interface{}, []string, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if len(i.objects) == 0 {
+		return c.UserData, path, nil
+	}
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("missing object name")
+	}
+	obj, found := i.objects[path[0]]
+	if !found {
+		return nil, nil, fmt.Errorf("unknown object '%s'", path[0])
+	}
+	return obj, path[1:], nil
+}
+
+func (i *Inspector) names() (names []string) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for name := range i.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func splitPath(path string) []string {
+	if path == "" || path == "." {
+		return nil
+	}
+	return strings.Split(strings.Trim(path, "."), ".")
+}
+
+func lock(obj interface{}, write bool) func() {
+	mp, ok := obj.(MutexProvider)
+	if !ok {
+		return func() {}
+	}
+	mu := mp.Mutex()
+	if write {
+		mu.Lock()
+		return mu.Unlock
+	}
+	mu.RLock()
+	return mu.RUnlock
+}
+
+// location is the result of walking a path. If the value is an element of a
+// map, m and key are set since map elements are not addressable.
+type location struct {
+	v        reflect.Value
+	m        reflect.Value
+	key      reflect.Value
+	readonly bool
+}
+
+func parseTag(f reflect.StructField) (name string, readonly, hidden bool) {
+	name = f.Name
+	tag, ok := f.Tag.Lookup("telgo")
+	if !ok {
+		return
+	}
+	if tag == "-" {
+		hidden = true
+		return
+	}
+	parts := strings.Split(tag, ",")
+	if parts[0] != "" {
+		name = parts[0]
+	}
+	for _, opt := range parts[1:] {
+		if opt == "readonly" {
+			readonly = true
+		}
+	}
+	return
+}
+
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func walk(obj interface{}, path []string) (loc location, err error) {
+	loc.v = reflect.ValueOf(obj)
+	for i, elem := range path {
+		loc.m = reflect.Value{}
+		v := indirect(loc.v)
+		if !v.IsValid() {
+			return loc, fmt.Errorf("'%s' is nil", strings.Join(path[:i], "."))
+		}
+		switch v.Kind() {
+		case reflect.Struct:
+			found := false
+			for j := 0; j < v.NumField(); j++ {
+				f := v.Type().Field(j)
+				name, ro, hidden := parseTag(f)
+				if f.PkgPath != "" || hidden || !strings.EqualFold(name, elem) {
+					continue
+				}
+				loc.v = v.Field(j)
+				loc.readonly = loc.readonly || ro
+				found = true
+				break
+			}
+			if !found {
+				return loc, fmt.Errorf("'%s' has no field '%s'", strings.Join(path[:i], "."), elem)
+			}
+		case reflect.Slice, reflect.Array:
+			idx, err := strconv.Atoi(elem)
+			if err != nil || idx < 0 || idx >= v.Len() {
+				return loc, fmt.Errorf("invalid index '%s' for '%s'", elem, strings.Join(path[:i], "."))
+			}
+			loc.v = v.Index(idx)
+		case reflect.Map:
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := SetFromString(key, elem); err != nil {
+				return loc, fmt.Errorf("invalid key '%s' for '%s': %v", elem, strings.Join(path[:i], "."), err)
+			}
+			val := v.MapIndex(key)
+			if !val.IsValid() {
+				return loc, fmt.Errorf("'%s' has no key '%s'", strings.Join(path[:i], "."), elem)
+			}
+			loc.v = val
+			loc.m = v
+			loc.key = key
+		default:
+			return loc, fmt.Errorf("'%s' is a %s and has no element '%s'", strings.Join(path[:i], "."), v.Kind(), elem)
+		}
+	}
+	return loc, nil
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	stringerType        = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+// CanSetFromString reports whether SetFromString supports values of type t.
+// Interface types are not reported as supported since it depends on the
+// dynamic type of the value.
+func CanSetFromString(t reflect.Type) bool {
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) || t == durationType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Ptr:
+		return CanSetFromString(t.Elem())
+	}
+	return false
+}
+
+// SetFromString converts s to the type of v and assigns it to v which must be
+// settable. Besides all basic types, time.Duration, pointers to supported
+// types and types implementing encoding.TextUnmarshaler are supported.
+func SetFromString(v reflect.Value, s string) error {
+	if reflect.PtrTo(v.Type()).Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Ptr:
+		n := reflect.New(v.Type().Elem())
+		if err := SetFromString(n.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(n)
+	case reflect.Interface:
+		if v.IsNil() {
+			return fmt.Errorf("the type of a nil interface value is unknown")
+		}
+		n := reflect.New(v.Elem().Type()).Elem()
+		if err := SetFromString(n, s); err != nil {
+			return err
+		}
+		v.Set(n)
+	default:
+		return fmt.Errorf("values of type %s can't be set", v.Type())
+	}
+	return nil
+}
+
+// isLeaf returns true if v should be printed as a single value.
+func isLeaf(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	t := v.Type()
+	if t.Implements(textMarshalerType) || t.Implements(stringerType) || t == durationType {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		return v.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil() || isLeaf(v.Elem())
+	}
+	return true
+}
+
+func format(v reflect.Value) string {
+	if !v.IsValid() {
+		return "<nil>"
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return "<nil>"
+	}
+	if v.CanInterface() {
+		if tm, ok := v.Interface().(encoding.TextMarshaler); ok {
+			if b, err := tm.MarshalText(); err == nil {
+				return string(b)
+			}
+		}
+		return fmt.Sprintf("%v", v.Interface())
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// show appends a line for every leaf value found below v to lines
+func show(lines []string, path string, v reflect.Value, readonly bool, depth int) []string {
+	ro := ""
+	if readonly {
+		ro = " (read-only)"
+	}
+	if isLeaf(v) || depth > maxDepth {
+		return append(lines, fmt.Sprintf("%s = %s%s", path, format(v), ro))
+	}
+	prefix := path
+	if prefix != "" {
+		prefix += "."
+	}
+	v = indirect(v)
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			name, fro, hidden := parseTag(f)
+			if f.PkgPath != "" || hidden {
+				continue
+			}
+			lines = show(lines, prefix+name, v.Field(i), readonly || fro, depth+1)
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Len() == 0 {
+			return append(lines, fmt.Sprintf("%s = []%s", path, ro))
+		}
+		for i := 0; i < v.Len(); i++ {
+			lines = show(lines, prefix+strconv.Itoa(i), v.Index(i), readonly, depth+1)
+		}
+	case reflect.Map:
+		if v.Len() == 0 {
+			return append(lines, fmt.Sprintf("%s = {}%s", path, ro))
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return format(keys[i]) < format(keys[j]) })
+		for _, key := range keys {
+			lines = show(lines, prefix+format(key), v.MapIndex(key), readonly, depth+1)
+		}
+	}
+	return lines
+}
+
+// lookup formats the value found at p using fn while the object holding it
+// is locked. The lines are printed by the caller once the lock has been
+// released so a slow client can't stall the application.
+func (i *Inspector) lookup(c *telgo.Client, p string, fn func(loc location) []string) ([]string, error) {
+	obj, path, err := i.root(c, splitPath(p))
+	if err != nil {
+		return nil, err
+	}
+	defer lock(obj, false)()
+	loc, err := walk(obj, path)
+	if err != nil {
+		return nil, err
+	}
+	return fn(loc), nil
+}
+
+func sayLines(c *telgo.Client, lines []string) bool {
+	for _, line := range lines {
+		if !c.Sayln("%s", line) {
+			return false
+		}
+	}
+	return true
+}
+
+// Get prints the value found at the path supplied as argument.
+func (i *Inspector) Get(c *telgo.Client, args []string) bool {
+	if len(args) != 2 {
+		c.Errorf("usage: %s <path>", args[0])
+		return false
+	}
+	lines, err := i.lookup(c, args[1], func(loc location) []string {
+		if isLeaf(loc.v) {
+			return []string{format(loc.v)}
+		}
+		return show(nil, args[1], loc.v, loc.readonly, 0)
+	})
+	if err != nil {
+		c.Errorf("%v", err)
+		return false
+	}
+	sayLines(c, lines)
+	return false
+}
+
+// Show prints all values found below the path supplied as argument. If the
+// path is omitted everything is shown.
+func (i *Inspector) Show(c *telgo.Client, args []string) bool {
+	if len(args) > 2 {
+		c.Errorf("usage: %s [<path>]", args[0])
+		return false
+	}
+	p := ""
+	if len(args) == 2 {
+		p = args[1]
+	}
+	if p == "" && len(i.names()) > 0 {
+		for _, name := range i.names() {
+			lines, err := i.lookup(c, name, func(loc location) []string {
+				return show(nil, name, loc.v, loc.readonly, 0)
+			})
+			if err != nil {
+				c.Errorf("%v", err)
+				return false
+			}
+			if !sayLines(c, lines) {
+				return false
+			}
+		}
+		return false
+	}
+	lines, err := i.lookup(c, p, func(loc location) []string {
+		return show(nil, strings.Trim(p, "."), loc.v, loc.readonly, 0)
+	})
+	if err != nil {
+		c.Errorf("%v", err)
+		return false
+	}
+	sayLines(c, lines)
+	return false
+}
+
+// Set assigns a new value to the exported field, slice or map element found
+// at the path supplied as first argument. The value will be converted to the
+// type of the destination.
+func (i *Inspector) Set(c *telgo.Client, args []string) bool {
+	if len(args) != 3 {
+		c.Errorf("usage: %s <path> <value>", args[0])
+		return false
+	}
+	if err := i.set(c, args[1], args[2]); err != nil {
+		c.Errorf("%v", err)
+	}
+	return false
+}
+
+func (i *Inspector) set(c *telgo.Client, p, value string) error {
+	obj, path, err := i.root(c, splitPath(p))
+	if err != nil {
+		return err
+	}
+	if len(path) == 0 {
+		return fmt.Errorf("'%s' can't be replaced as a whole", p)
+	}
+	defer lock(obj, true)()
+	loc, err := walk(obj, path)
+	if err != nil {
+		return err
+	}
+	if loc.readonly {
+		return fmt.Errorf("'%s' is read-only", p)
+	}
+
+	if loc.m.IsValid() {
+		nv := reflect.New(loc.m.Type().Elem()).Elem()
+		nv.Set(loc.v)
+		if err := SetFromString(nv, value); err != nil {
+			return fmt.Errorf("invalid value for '%s': %v", p, err)
+		}
+		loc.m.SetMapIndex(loc.key, nv)
+		return nil
+	}
+	if !loc.v.CanSet() {
+		return fmt.Errorf("'%s' can't be modified", p)
+	}
+	if err := SetFromString(loc.v, value); err != nil {
+		return fmt.Errorf("invalid value for '%s': %v", p, err)
+	}
+	return nil
+}
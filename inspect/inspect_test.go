@@ -0,0 +1,165 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package inspect_test
+
+import (
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spreadspace/telgo"
+	"github.com/spreadspace/telgo/inspect"
+	"github.com/spreadspace/telgo/telgotest"
+)
+
+func TestSetFromString(t *testing.T) {
+	var iface interface{} = 1
+	for _, tt := range []struct {
+		v     interface{} // pointer to the destination
+		s     string
+		want  interface{}
+		error bool
+	}{
+		{new(string), "hello world", "hello world", false},
+		{new(bool), "true", true, false},
+		{new(bool), "yes", false, true},
+		{new(int), "-42", -42, false},
+		{new(int), "0x10", 16, false},
+		{new(int8), "127", int8(127), false},
+		{new(int8), "128", int8(0), true},
+		{new(uint16), "65535", uint16(65535), false},
+		{new(uint), "-1", uint(0), true},
+		{new(float32), "1.5", float32(1.5), false},
+		{new(float64), "1e3", 1000.0, false},
+		{new(float64), "one", 0.0, true},
+		{new(time.Duration), "1m30s", 90 * time.Second, false},
+		{new(time.Duration), "90", time.Duration(0), true},
+		{new(*int), "7", func() *int { i := 7; return &i }(), false},
+		{new(net.IP), "192.0.2.1", net.ParseIP("192.0.2.1"), false},
+		{new(net.IP), "192.0.2", net.IP(nil), true},
+		{&iface, "23", 23, false},
+		{new([]int), "1", []int(nil), true},
+	} {
+		v := reflect.ValueOf(tt.v).Elem()
+		err := inspect.SetFromString(v, tt.s)
+		if (err != nil) != tt.error {
+			t.Errorf("%s as %s: unexpected error: %v", tt.s, v.Type(), err)
+			continue
+		}
+		if !tt.error && !reflect.DeepEqual(v.Interface(), tt.want) {
+			t.Errorf("%s as %s: expected %v, got %v", tt.s, v.Type(), tt.want, v.Interface())
+		}
+	}
+	var nilIface interface{}
+	if err := inspect.SetFromString(reflect.ValueOf(&nilIface).Elem(), "1"); err == nil {
+		t.Error("setting a nil interface value should fail")
+	}
+}
+
+func TestCanSetFromString(t *testing.T) {
+	for _, tt := range []struct {
+		v    interface{}
+		want bool
+	}{
+		{"", true},
+		{uint8(0), true},
+		{time.Second, true},
+		{new(float64), true},
+		{net.IP{}, true},
+		{[]string{}, false},
+		{map[string]int{}, false},
+		{struct{}{}, false},
+	} {
+		if got := inspect.CanSetFromString(reflect.TypeOf(tt.v)); got != tt.want {
+			t.Errorf("%T: expected %v, got %v", tt.v, tt.want, got)
+		}
+	}
+}
+
+type limits struct {
+	Max     int
+	Timeout time.Duration
+}
+
+type config struct {
+	mu      sync.RWMutex
+	Name    string
+	Limits  limits
+	Servers []string
+	Weights map[string]float64
+	Version string `telgo:"version,readonly"`
+	Secret  string `telgo:"-"`
+}
+
+func (c *config) Mutex() *sync.RWMutex { return &c.mu }
+
+func TestCommands(t *testing.T) {
+	cfg := &config{
+		Name:    "test",
+		Limits:  limits{Max: 10, Timeout: time.Second},
+		Servers: []string{"a", "b"},
+		Weights: map[string]float64{"a": 0.5},
+		Version: "1.0",
+		Secret:  "hidden",
+	}
+	cmds := make(telgo.CmdList)
+	inspect.Register(cmds)
+	h := telgotest.New(t, "> ", cmds, cfg)
+	s := h.Connect()
+	s.ExpectPrompt()
+
+	for _, tt := range []struct {
+		line, want string
+	}{
+		{"get name", "test\n"},
+		{"get LIMITS.max", "10\n"},
+		{"get limits", "limits.Max = 10\nlimits.Timeout = 1s\n"},
+		{"set limits.max 0x20", ""},
+		{"set limits.timeout 2m", ""},
+		{"get limits", "limits.Max = 32\nlimits.Timeout = 2m0s\n"},
+		{"set servers.1 c", ""},
+		{"get servers.1", "c\n"},
+		{"set weights.a 2.5", ""},
+		{"get weights.a", "2.5\n"},
+		{"set limits.max many", "error: invalid value for 'limits.max': strconv.ParseInt: parsing \"many\": invalid syntax\n"},
+		{"set version 2.0", "error: 'version' is read-only\n"},
+		{"get secret", "error: '' has no field 'secret'\n"},
+		{"show", "Name = test\nLimits.Max = 32\nLimits.Timeout = 2m0s\nServers.0 = a\nServers.1 = c\nWeights.a = 2.5\nversion = 1.0 (read-only)\n"},
+	} {
+		if out := s.Run(tt.line); out != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.line, tt.want, out)
+		}
+	}
+	if cfg.Limits.Max != 32 || cfg.Limits.Timeout != 2*time.Minute || cfg.Servers[1] != "c" || cfg.Weights["a"] != 2.5 || cfg.Version != "1.0" {
+		t.Errorf("unexpected configuration: %+v", cfg)
+	}
+}
@@ -0,0 +1,194 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+)
+
+// Exit status values reported to clients in machine mode and returned by
+// Server.ExecConn.
+const (
+	StatusOK        = 0
+	StatusError     = 1
+	StatusCancelled = 130
+)
+
+// MachineRequest is a single request sent by a client in machine mode. Cmd
+// is the name of the command and Args are its arguments, they are passed to
+// the command exactly as given and don't need any quoting. ID is an arbitrary
+// JSON value which is copied to the response.
+type MachineRequest struct {
+	ID   json.RawMessage `json:"id,omitempty"`
+	Cmd  string          `json:"cmd"`
+	Args []string        `json:"args,omitempty"`
+}
+
+// MachineResponse is sent to clients in machine mode after every request.
+// Output contains everything the command has written, split into lines.
+// Status is one of StatusOK, StatusError or StatusCancelled and Error
+// contains the error message if the command failed, see Client.Errorf. Quit
+// is true if the server is going to close the connection.
+type MachineResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Output []string        `json:"output"`
+	Error  string          `json:"error,omitempty"`
+	Status int             `json:"status"`
+	Quit   bool            `json:"quit,omitempty"`
+}
+
+// MachineHello is sent to the client when it enters machine mode using
+// MachineCmd. Clients should wait for this line before sending requests.
+const MachineHello = `{"telgo":"machine","version":1}`
+
+// MachineMode returns true if the session is in machine mode.
+func (c *Client) MachineMode() bool {
+	c.termMu.Lock()
+	defer c.termMu.Unlock()
+	return c.machine
+}
+
+// SetMachineMode sets whether all client sessions of this server start in
+// machine mode. This is meant to be used for a separate listener dedicated
+// to programmatic clients. Sessions which start in machine mode don't run
+// the greeter and don't negotiate any telnet options, every line sent to the
+// client is a JSON object.
+func (s *Server) SetMachineMode(enabled bool) {
+	s.machine = enabled
+}
+
+// MachineCmd is a telgo command which switches the session into machine
+// mode. In machine mode every line sent by the client must be a JSON encoded
+// MachineRequest. The commands of the server are run as usual but their
+// output is collected and returned together with the exit status as a
+// MachineResponse encoded as a single line of JSON. There is no prompt, no
+// pager and no styled output in machine mode. Ctrl-C, sent as telnet
+// interrupt process command, still cancels the running command.
+func MachineCmd(c *Client, args []string) bool {
+	c.termMu.Lock()
+	c.machine = true
+	c.termMu.Unlock()
+	c.log.Info("entering machine mode")
+	c.writeRaw(MachineHello + "\r\n")
+	return false
+}
+
+func (c *Client) startCapture() {
+	c.termMu.Lock()
+	defer c.termMu.Unlock()
+	c.capture = &bytes.Buffer{}
+}
+
+func (c *Client) stopCapture() string {
+	c.termMu.Lock()
+	defer c.termMu.Unlock()
+	out := c.capture.String()
+	c.capture = nil
+	return out
+}
+
+// captureOutput returns false if output isn't captured at the moment.
+func (c *Client) captureOutput(text string) bool {
+	c.termMu.Lock()
+	defer c.termMu.Unlock()
+	if c.capture == nil {
+		return false
+	}
+	c.capture.WriteString(text)
+	return true
+}
+
+func splitOutput(out string) []string {
+	out = strings.Replace(out, "\r\n", "\n", -1)
+	out = strings.TrimSuffix(out, "\n")
+	if out == "" {
+		return []string{}
+	}
+	return strings.Split(out, "\n")
+}
+
+func (c *Client) handleMachineCmd(line string, done chan<- bool) {
+	quit := false
+	defer func() { done <- quit }()
+
+	var req MachineRequest
+	if err := json.Unmarshal([]byte(line), &req); err != nil {
+		c.log.Warn("can't parse machine request", "error", err)
+		c.respond(&MachineResponse{Output: []string{}, Error: "can't parse request: " + err.Error(), Status: StatusError})
+		return
+	}
+	resp := &MachineResponse{ID: req.ID, Output: []string{}}
+	if req.Cmd == "" {
+		resp.Error = "missing command"
+		resp.Status = StatusError
+		c.respond(resp)
+		return
+	}
+
+	cmdslice := append([]string{req.Cmd}, req.Args...)
+	quoted := make([]string, len(cmdslice))
+	for i, arg := range cmdslice {
+		quoted[i] = QuoteArgument(arg)
+	}
+	c.startCapture()
+	var err error
+	quit, err = c.runCmd(strings.Join(quoted, " "), cmdslice)
+	resp.Output = splitOutput(c.stopCapture())
+	resp.Quit = quit
+	if resp.Status = c.exitStatus(err); resp.Status == StatusCancelled {
+		resp.Error = "cancelled"
+	} else if err != nil {
+		resp.Error = err.Error()
+	}
+	c.respond(resp)
+}
+
+// exitStatus returns the exit status of the last command which returned err
+func (c *Client) exitStatus(err error) int {
+	switch {
+	case atomic.LoadInt32(&c.cancelled) != 0:
+		return StatusCancelled
+	case err != nil:
+		return StatusError
+	}
+	return StatusOK
+}
+
+func (c *Client) respond(resp *MachineResponse) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		c.log.Error("can't encode machine response", "error", err)
+		return
+	}
+	c.writeRaw(string(data) + "\r\n")
+}
@@ -0,0 +1,64 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo_test
+
+import (
+	"testing"
+
+	"github.com/spreadspace/telgo"
+	"github.com/spreadspace/telgo/telgotest"
+)
+
+func TestMachineCancel(t *testing.T) {
+	started := make(chan bool)
+	cmds := telgo.CmdList{
+		"wait": func(c *telgo.Client, args []string) bool {
+			started <- true
+			<-c.Context().Done()
+			return false
+		},
+		"echo": func(c *telgo.Client, args []string) bool {
+			c.Sayln("%s", args[1])
+			return false
+		},
+	}
+	h := telgotest.New(t, "", cmds, nil)
+	h.Server.SetMachineMode(true)
+	s := h.Connect()
+
+	s.Type(`{"id":1,"cmd":"wait"}`)
+	<-started
+	s.CtrlC()
+	s.Expect(`{"id":1,"output":[],"error":"cancelled","status":130}` + "\r\n")
+
+	// the cancel request must not affect the next request
+	s.Type(`{"id":2,"cmd":"echo","args":["hello"]}`)
+	s.Expect(`{"id":2,"output":["hello"],"status":0}` + "\r\n")
+}
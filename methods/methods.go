@@ -0,0 +1,303 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+// Package methods turns the exported methods of a Go object into telgo
+// commands. A method like
+//
+//	func (s *Svc) RestartUnit(ctx context.Context, name string, force bool) error
+//
+// becomes the command "restart-unit <name> <force>". The arguments supplied
+// by the user are converted to the types of the parameters, see
+// inspect.SetFromString for the supported types. Optional leading parameters
+// of type context.Context and *telgo.Client receive the context of the
+// command, which is canceled if the user hits Ctrl-C, and the client. Return
+// values are printed to the client, a non-nil error as last return value is
+// reported as such.
+//
+// The command name, argument names and a help text may be set using struct
+// tags on blank fields of the object:
+//
+//	type Svc struct {
+//		_ struct{} `telgo:"RestartUnit" name:"restart" args:"unit force" help:"restart a unit"`
+//	}
+//
+// Every generated command prints its help text if it is called with -h or
+// --help as the only argument.
+package methods
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/spreadspace/telgo"
+	"github.com/spreadspace/telgo/inspect"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	clientType  = reflect.TypeOf((*telgo.Client)(nil))
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+type method struct {
+	name     string
+	fn       reflect.Value
+	withCtx  bool
+	withCl   bool
+	params   []reflect.Type // for variadic methods the last one is the element type
+	variadic bool
+	args     []string
+	help     string
+}
+
+// CmdName converts a method name like RestartUnit to the command name
+// restart-unit.
+func CmdName(method string) string {
+	var b strings.Builder
+	runes := []rune(method)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteRune('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func parseTags(t reflect.Type) map[string]reflect.StructTag {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	tags := make(map[string]reflect.StructTag)
+	if t.Kind() != reflect.Struct {
+		return tags
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if m, ok := f.Tag.Lookup("telgo"); ok && f.Name == "_" {
+			tags[m] = f.Tag
+		}
+	}
+	return tags
+}
+
+func newMethod(m reflect.Method, fn reflect.Value, tag reflect.StructTag) (*method, error) {
+	cm := &method{name: CmdName(m.Name), fn: fn, variadic: fn.Type().IsVariadic()}
+	if name, ok := tag.Lookup("name"); ok {
+		cm.name = name
+	}
+	cm.help = tag.Get("help")
+
+	t := fn.Type()
+	i := 0
+	if i < t.NumIn() && t.In(i) == contextType {
+		cm.withCtx = true
+		i++
+	}
+	if i < t.NumIn() && t.In(i) == clientType {
+		cm.withCl = true
+		i++
+	}
+	for ; i < t.NumIn(); i++ {
+		pt := t.In(i)
+		if cm.variadic && i == t.NumIn()-1 {
+			pt = pt.Elem()
+		}
+		if !inspect.CanSetFromString(pt) {
+			return nil, fmt.Errorf("method %s: parameter %d has unsupported type %s", m.Name, i, pt)
+		}
+		cm.params = append(cm.params, pt)
+	}
+
+	if args, ok := tag.Lookup("args"); ok {
+		cm.args = strings.Fields(args)
+		if len(cm.args) != len(cm.params) {
+			return nil, fmt.Errorf("method %s: args tag names %d arguments but the method has %d", m.Name, len(cm.args), len(cm.params))
+		}
+	} else {
+		for _, pt := range cm.params {
+			cm.args = append(cm.args, pt.String())
+		}
+	}
+	return cm, nil
+}
+
+func (m *method) usage() string {
+	u := m.name
+	for i, arg := range m.args {
+		if m.variadic && i == len(m.args)-1 {
+			u += fmt.Sprintf(" [<%s> ...]", arg)
+		} else {
+			u += fmt.Sprintf(" <%s>", arg)
+		}
+	}
+	return u
+}
+
+func (m *method) sayResult(c *telgo.Client, v reflect.Value) {
+	if !v.IsValid() {
+		return
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			for i := 0; i < v.Len(); i++ {
+				c.Sayln("%v", v.Index(i).Interface())
+			}
+			return
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, k := range keys {
+			c.Sayln("%v: %v", k.Interface(), v.MapIndex(k).Interface())
+		}
+		return
+	}
+	c.Sayln("%v", v.Interface())
+}
+
+// cmd converts the arguments and calls the method. Panics of the method are
+// reported to the user instead of taking down the whole server.
+func (m *method) cmd(c *telgo.Client, args []string) (quit bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.Errorf("%s: panic: %v", m.name, r)
+			quit = false
+		}
+	}()
+	args = args[1:]
+	if len(args) == 1 && (args[0] == "-h" || args[0] == "--help") {
+		c.Sayln("usage: %s", m.usage())
+		if m.help != "" {
+			c.Sayln("")
+			c.Sayln("%s", m.help)
+		}
+		return false
+	}
+	fixed := len(m.params)
+	if m.variadic {
+		fixed--
+	}
+	if len(args) < fixed || (!m.variadic && len(args) > fixed) {
+		c.Errorf("usage: %s", m.usage())
+		return false
+	}
+
+	var in []reflect.Value
+	if m.withCtx {
+		in = append(in, reflect.ValueOf(c.Context()))
+	}
+	if m.withCl {
+		in = append(in, reflect.ValueOf(c))
+	}
+	for i, arg := range args {
+		pt := m.params[len(m.params)-1]
+		name := m.args[len(m.args)-1]
+		if i < fixed {
+			pt = m.params[i]
+			name = m.args[i]
+		}
+		v := reflect.New(pt).Elem()
+		if err := inspect.SetFromString(v, arg); err != nil {
+			c.Errorf("invalid value '%s' for %s: %v", arg, name, err)
+			return false
+		}
+		in = append(in, v)
+	}
+
+	out := m.fn.Call(in)
+	if len(out) > 0 && m.fn.Type().Out(len(out)-1) == errorType {
+		last := out[len(out)-1]
+		out = out[:len(out)-1]
+		if !last.IsNil() {
+			c.Errorf("%v", last.Interface())
+			return false
+		}
+	}
+	for _, v := range out {
+		m.sayResult(c, v)
+	}
+	return false
+}
+
+// Commands creates a telgo command for every exported method of obj, which
+// must be a non-nil pointer. Methods with parameters of unsupported types are
+// skipped. Methods may be excluded explicitly using a tag like
+// `telgo:"Method" name:"-"`. An error is returned if the tags of obj are
+// inconsistent with its methods.
+func Commands(obj interface{}) (telgo.CmdList, error) {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil, fmt.Errorf("object must be a non-nil pointer, got %T", obj)
+	}
+	t := v.Type()
+	tags := parseTags(t)
+
+	cmds := make(telgo.CmdList)
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		tag := tags[m.Name]
+		delete(tags, m.Name)
+		if tag.Get("name") == "-" {
+			continue
+		}
+		cm, err := newMethod(m, v.Method(i), tag)
+		if err != nil {
+			if tag != "" {
+				return nil, err
+			}
+			continue
+		}
+		cmds[cm.name] = cm.cmd
+	}
+	for name := range tags {
+		return nil, fmt.Errorf("tag refers to unknown method %s", name)
+	}
+	return cmds, nil
+}
+
+// Register adds the commands created by Commands to cmds.
+func Register(cmds telgo.CmdList, obj interface{}) error {
+	l, err := Commands(obj)
+	if err != nil {
+		return err
+	}
+	for name, cmd := range l {
+		cmds[name] = cmd
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package methods_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spreadspace/telgo"
+	"github.com/spreadspace/telgo/methods"
+	"github.com/spreadspace/telgo/telgotest"
+)
+
+func TestCmdName(t *testing.T) {
+	for method, want := range map[string]string{
+		"Restart":     "restart",
+		"RestartUnit": "restart-unit",
+		"HTTPStatus":  "http-status",
+		"GetHTTP":     "get-http",
+		"ListV2":      "list-v2",
+	} {
+		if got := methods.CmdName(method); got != want {
+			t.Errorf("%s: expected %s, got %s", method, want, got)
+		}
+	}
+}
+
+type svc struct {
+	_ struct{} `telgo:"Restart" name:"restart" args:"unit force" help:"restart a unit"`
+	_ struct{} `telgo:"Internal" name:"-"`
+
+	values []int
+}
+
+func (s *svc) Add(a, b int) int { return a + b }
+func (s *svc) Scale(f float64, d time.Duration) string {
+	return time.Duration(f * float64(d)).String()
+}
+func (s *svc) Join(sep string, parts ...string) string { return strings.Join(parts, sep) }
+func (s *svc) Flags(b bool, u uint8, p *int) []string {
+	return []string{fmt.Sprint(b), fmt.Sprint(u), strings.Repeat("*", *p)}
+}
+func (s *svc) Sizes() map[string]int            { return map[string]int{"b": 2, "a": 1} }
+func (s *svc) Fail() error                      { return errors.New("boom") }
+func (s *svc) Internal() string                 { return "hidden" }
+func (s *svc) Crash() int                       { return s.values[3] }
+func (s *svc) Unsupported(m map[string]int) int { return len(m) }
+func (s *svc) Restart(ctx context.Context, c *telgo.Client, unit string, force bool) error {
+	if ctx == nil || c == nil {
+		return errors.New("missing context or client")
+	}
+	c.Sayln("restarting %s (force=%v)", unit, force)
+	return nil
+}
+
+func TestCommands(t *testing.T) {
+	cmds, err := methods.Commands(&svc{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"internal", "unsupported"} {
+		if _, found := cmds[name]; found {
+			t.Errorf("method %s should not be a command", name)
+		}
+	}
+	h := telgotest.New(t, "$ ", cmds, nil) // usage messages contain "> "
+	s := h.Connect()
+	s.ExpectPrompt()
+
+	for _, tt := range []struct {
+		line, want string
+	}{
+		{"add 1 0x10", "17\n"},
+		{"add -1", "error: usage: add <int> <int>\n"},
+		{"add 1 2 3", "error: usage: add <int> <int>\n"},
+		{"add one 2", "error: invalid value 'one' for int: strconv.ParseInt: parsing \"one\": invalid syntax\n"},
+		{"scale 1.5 1m", "1m30s\n"},
+		{"scale 1 soon", "error: invalid value 'soon' for time.Duration: time: invalid duration \"soon\"\n"},
+		{"join ,", "\n"},
+		{`join , a "b c" d`, "a,b c,d\n"},
+		{"flags true 13 3", "true\n13\n***\n"},
+		{"flags maybe 1 1", "error: invalid value 'maybe' for bool: strconv.ParseBool: parsing \"maybe\": invalid syntax\n"},
+		{"flags true 256 1", "error: invalid value '256' for uint8: strconv.ParseUint: parsing \"256\": value out of range\n"},
+		{"sizes", "a: 1\nb: 2\n"},
+		{"fail", "error: boom\n"},
+		{"restart web yes", "error: invalid value 'yes' for force: strconv.ParseBool: parsing \"yes\": invalid syntax\n"},
+		{"restart web true", "restarting web (force=true)\n"},
+		{"restart --help", "usage: restart <unit> <force>\n\nrestart a unit\n"},
+		{"add -h", "usage: add <int> <int>\n"},
+		{"crash", "error: crash: panic: runtime error: index out of range [3] with length 0\n"},
+	} {
+		if out := s.Run(tt.line); out != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.line, tt.want, out)
+		}
+	}
+}
+
+type badTags struct {
+	_ struct{} `telgo:"Missing" name:"missing"`
+}
+
+type badArgs struct {
+	_ struct{} `telgo:"Add" args:"a"`
+}
+
+func (b *badArgs) Add(a, b2 int) int { return a + b2 }
+
+func TestCommandsErrors(t *testing.T) {
+	if _, err := methods.Commands(&badTags{}); err == nil {
+		t.Error("a tag for an unknown method should fail")
+	}
+	if _, err := methods.Commands(&badArgs{}); err == nil {
+		t.Error("an args tag with the wrong number of names should fail")
+	}
+	var nilSvc *svc
+	for _, obj := range []interface{}{nil, nilSvc, svc{}} {
+		if _, err := methods.Commands(obj); err == nil {
+			t.Errorf("%#v: expected an error", obj)
+		}
+	}
+}
@@ -0,0 +1,394 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// telnet options
+const (
+	optEcho  = byte(1)
+	optSGA   = byte(3)
+	optTTYPE = byte(24)
+	optNAWS  = byte(31)
+)
+
+var (
+	telnetOptions = map[byte]string{
+		0:        "BINARY",
+		optEcho:  "ECHO",
+		optSGA:   "SGA",
+		5:        "STATUS",
+		6:        "TIMING-MARK",
+		optTTYPE: "TERMINAL-TYPE",
+		optNAWS:  "NAWS",
+		32:       "TERMINAL-SPEED",
+		33:       "TOGGLE-FLOW-CONTROL",
+		34:       "LINEMODE",
+		35:       "X-DISPLAY-LOCATION",
+		36:       "ENVIRON",
+		39:       "NEW-ENVIRON",
+	}
+
+	// all options the client is allowed to enable, they will be requested
+	// as soon as the client connects
+	supportedRemoteOptions = []byte{optNAWS, optTTYPE}
+
+	// all options the server may enable, together they switch the client
+	// into character mode
+	supportedLocalOptions = []byte{optEcho, optSGA}
+)
+
+const (
+	defaultWidth  = 80
+	defaultHeight = 24
+
+	ttypeIS   = byte(0)
+	ttypeSEND = byte(1)
+)
+
+func optionName(opt byte) string {
+	if name, found := telnetOptions[opt]; found {
+		return name
+	}
+	return "unknown"
+}
+
+// remoteOption holds the negotiation state of an option which the client may
+// enable. This is only accessed by the receiving go routine.
+type remoteOption struct {
+	enabled   bool
+	requested bool
+}
+
+// negotiation states of local options, see RFC 1143
+const (
+	qNo = iota
+	qYes
+	qWantNo
+	qWantYes
+)
+
+// localOption holds the negotiation state of an option which the server may
+// enable. If opposite is set the option will be negotiated again as soon as
+// the client has answered. This is protected by Client.optMu.
+type localOption struct {
+	state    int
+	opposite bool
+	refused  bool
+}
+
+// iacLength returns the length of the telnet command at the beginning of data
+// or 0 if data does not yet contain the complete command.
+func iacLength(data []byte) int {
+	if len(data) < 2 {
+		return 0
+	}
+	if data[1] == bSB { // subnegotiation parameters are terminated by IAC SE
+		for i := 2; i+1 < len(data); i++ {
+			if data[i] == bIAC {
+				if data[i+1] == bSE {
+					return i + 2
+				}
+				i++ // skip escaped IAC
+			}
+		}
+		return 0
+	}
+	l := 2 // if we don't know this command - assume it has a length of 2
+	if cmd, found := telnetCmds[data[1]]; found {
+		l = cmd.length
+	}
+	if len(data) < l {
+		return 0
+	}
+	return l
+}
+
+// negotiate requests all supported options from the client. Sessions which
+// start in machine mode expect nothing but JSON, see Server.SetMachineMode.
+func (c *Client) negotiate() {
+	if c.MachineMode() || c.plain {
+		c.rec.begin() // there won't be a window size
+		return
+	}
+	for _, opt := range supportedRemoteOptions {
+		c.remoteOpts[opt] = &remoteOption{requested: true}
+		c.log.Debug("requesting telnet option", "option", optionName(opt))
+		c.sendIac([]byte{bIAC, bDO, opt})
+	}
+}
+
+// sendIac queues a telnet command for the sending go routine. The command
+// is dropped if the session is about to be closed.
+func (c *Client) sendIac(cmd []byte) {
+	select {
+	case c.iacout <- cmd:
+	case <-c.quitSend:
+	}
+}
+
+// parse the telnet command and send out out-of-band responses to them
+func (c *Client) handleIac(iac []byte) {
+	iac = append([]byte(nil), iac...) // the scanner might reuse the buffer before the sender is done
+	switch iac[1] {
+	case bWILL:
+		c.handleWill(iac[2])
+		return
+	case bWONT:
+		c.handleWont(iac[2])
+		return
+	case bDO:
+		c.handleDo(iac[2])
+		return
+	case bDONT:
+		c.handleDont(iac[2])
+		return
+	case bSB:
+		c.handleSubnegotiation(bytes.Replace(iac[2:len(iac)-2], []byte{bIAC, bIAC}, []byte{bIAC}, -1))
+		return
+	case bIP:
+		// pass this through to client.handle which will cancel the process
+	case bIAC:
+		return // just an escaped IAC, this will be dealt with by dropIAC
+	default:
+		c.log.Debug("ignoring unimplemented telnet command", "command", telnetCmds[iac[1]].name, "description", telnetCmds[iac[1]].description)
+		return
+	}
+	c.sendIac(iac)
+}
+
+func (c *Client) handleWill(opt byte) {
+	o, supported := c.remoteOpts[opt]
+	if !supported {
+		c.log.Debug("refusing telnet option", "command", "WILL", "option", optionName(opt))
+		c.sendIac([]byte{bIAC, bDONT, opt}) // deny the client to use any unsupported options
+		return
+	}
+	if o.enabled {
+		return // nothing changed
+	}
+	if !o.requested { // the client proposed this option on its own
+		c.sendIac([]byte{bIAC, bDO, opt})
+	}
+	o.enabled = true
+	o.requested = false
+	c.log.Debug("telnet option enabled", "option", optionName(opt))
+
+	if opt == optTTYPE { // ask the client for its terminal type
+		c.sendIac([]byte{bIAC, bSB, optTTYPE, ttypeSEND, bIAC, bSE})
+	}
+}
+
+func (c *Client) handleWont(opt byte) {
+	o, supported := c.remoteOpts[opt]
+	if !supported {
+		c.sendIac([]byte{bIAC, bDONT, opt})
+		return
+	}
+	if o.enabled {
+		c.sendIac([]byte{bIAC, bDONT, opt}) // acknowledge the change
+	}
+	if o.enabled || o.requested {
+		c.log.Debug("telnet option disabled", "option", optionName(opt))
+	}
+	o.enabled = false
+	o.requested = false
+	if opt == optNAWS {
+		c.rec.begin() // the window size won't be known
+	}
+}
+
+func (c *Client) handleDo(opt byte) {
+	c.optMu.Lock()
+	defer c.optMu.Unlock()
+	o, supported := c.localOpts[opt]
+	if !supported || o.state == qNo {
+		c.log.Debug("refusing telnet option", "command", "DO", "option", optionName(opt))
+		c.sendIac([]byte{bIAC, bWONT, opt}) // options are only enabled on demand
+		return
+	}
+	switch {
+	case o.state == qWantNo && !o.opposite:
+		o.state = qNo // the client answered our WONT with DO, don't insist
+	case o.state == qWantYes && o.opposite:
+		o.state = qWantNo
+		o.opposite = false
+		c.sendIac([]byte{bIAC, bWONT, opt})
+	case o.state != qYes:
+		o.state = qYes
+		o.opposite = false
+		c.log.Debug("telnet option enabled", "option", optionName(opt))
+	}
+	c.updateCharMode()
+}
+
+func (c *Client) handleDont(opt byte) {
+	c.optMu.Lock()
+	defer c.optMu.Unlock()
+	o, supported := c.localOpts[opt]
+	if !supported {
+		c.sendIac([]byte{bIAC, bWONT, opt})
+		return
+	}
+	switch o.state {
+	case qYes:
+		c.sendIac([]byte{bIAC, bWONT, opt}) // acknowledge the change
+		c.log.Debug("telnet option disabled", "option", optionName(opt))
+		o.state = qNo
+	case qWantNo:
+		if o.opposite {
+			o.state = qWantYes
+			o.opposite = false
+			c.sendIac([]byte{bIAC, bWILL, opt})
+		} else {
+			o.state = qNo
+			c.log.Debug("telnet option disabled", "option", optionName(opt))
+		}
+	case qWantYes:
+		o.state = qNo
+		o.opposite = false
+		o.refused = true // don't ask again
+		c.log.Debug("telnet option refused", "option", optionName(opt))
+	}
+	c.updateCharMode()
+}
+
+// updateCharMode must be called with c.optMu held.
+func (c *Client) updateCharMode() {
+	var mode int32
+	if c.localOpts[optEcho].state == qYes && c.localOpts[optSGA].state == qYes {
+		mode = 1
+	}
+	atomic.StoreInt32(&c.charMode, mode)
+}
+
+func (c *Client) inCharMode() bool {
+	return atomic.LoadInt32(&c.charMode) != 0
+}
+
+// setCharMode asks the client to switch to character mode, where every key
+// is sent immediately and isn't echoed, or back to line mode. Character mode
+// is in effect once the client has agreed. Clients which refused it once
+// won't be asked again.
+func (c *Client) setCharMode(enable bool) {
+	if c.plain {
+		return
+	}
+	c.optMu.Lock()
+	defer c.optMu.Unlock()
+	for _, opt := range supportedLocalOptions {
+		if enable && c.localOpts[opt].refused {
+			return
+		}
+	}
+	for _, opt := range supportedLocalOptions {
+		o := c.localOpts[opt]
+		switch {
+		case enable && o.state == qNo:
+			o.state = qWantYes
+			c.sendIac([]byte{bIAC, bWILL, opt})
+		case !enable && o.state == qYes:
+			o.state = qWantNo
+			c.sendIac([]byte{bIAC, bWONT, opt})
+		case o.state == qWantNo:
+			o.opposite = enable
+		case o.state == qWantYes:
+			o.opposite = !enable
+		}
+	}
+	c.updateCharMode()
+}
+
+func (c *Client) handleSubnegotiation(params []byte) {
+	if len(params) == 0 {
+		return
+	}
+	opt := params[0]
+	if o, supported := c.remoteOpts[opt]; !supported || !o.enabled {
+		c.log.Debug("ignoring subnegotiation for option which is not enabled", "option", optionName(opt))
+		return
+	}
+	switch opt {
+	case optNAWS:
+		if len(params) != 5 {
+			c.log.Debug("ignoring malformed NAWS subnegotiation", "length", len(params))
+			return
+		}
+		width := int(params[1])<<8 | int(params[2])
+		height := int(params[3])<<8 | int(params[4])
+		c.SetWindowSize(width, height)
+		c.rec.begin()
+	case optTTYPE:
+		if len(params) < 2 || params[1] != ttypeIS {
+			c.log.Debug("ignoring malformed TERMINAL-TYPE subnegotiation")
+			return
+		}
+		c.SetTerminalType(string(params[2:]))
+	}
+}
+
+// WindowSize returns the size of the user's terminal in columns and rows. If
+// the size is unknown, because the client doesn't support NAWS (RFC 1073), a
+// size of 80x24 will be returned.
+func (c *Client) WindowSize() (width, height int) {
+	c.termMu.Lock()
+	defer c.termMu.Unlock()
+	return c.width, c.height
+}
+
+// SetWindowSize updates the size of the user's terminal. This is done
+// automatically for telnet clients which support NAWS but may be used by
+// other transports. A value of 0 means the respective dimension is unknown
+// and the default will be used.
+func (c *Client) SetWindowSize(width, height int) {
+	if width <= 0 {
+		width = defaultWidth
+	}
+	if height <= 0 {
+		height = defaultHeight
+	}
+	c.termMu.Lock()
+	changed := c.width != width || c.height != height
+	c.width, c.height = width, height
+	c.termMu.Unlock()
+	if !changed {
+		return
+	}
+
+	c.log.Debug("window size changed", "width", width, "height", height)
+	c.rec.resize(width, height)
+	select {
+	case c.WindowChanged <- true:
+	default: // there is already a pending notification
+	}
+}
@@ -0,0 +1,214 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// pager pauses the output of a command whenever a screen full of lines has
+// been written and waits for the user to continue.
+type pager struct {
+	mu    sync.Mutex
+	c     *Client
+	lines int
+	limit int
+	quit  bool
+}
+
+// stripMore removes a trailing "| more" from the arguments. line is the
+// command line as entered by the user, a quoted or escaped pipe is kept. The
+// second return value reports whether it was found.
+func stripMore(line string, args []string) ([]string, bool) {
+	line = strings.TrimRightFunc(line, unicode.IsSpace)
+	if !strings.HasSuffix(line, "more") {
+		return args, false
+	}
+	line = strings.TrimRightFunc(strings.TrimSuffix(line, "more"), unicode.IsSpace)
+	if !strings.HasSuffix(line, "|") || strings.HasSuffix(line, "\\|") {
+		return args, false
+	}
+	n := len(args)
+	if n >= 2 && args[n-2] == "|" && args[n-1] == "more" {
+		return args[:n-2], true
+	}
+	if n >= 1 && args[n-1] == "|more" {
+		return args[:n-1], true
+	}
+	return args, false
+}
+
+func pageSize(c *Client) int {
+	_, height := c.WindowSize()
+	if height < 2 {
+		return 1
+	}
+	return height - 1 // leave room for the --More-- prompt
+}
+
+// startPager activates the pager for the current command if paging is enabled
+// for the session or force is true. The returned function deactivates it.
+// There is no pager in machine mode.
+func (c *Client) startPager(force bool) func() {
+	if (!force && !c.Paging()) || c.MachineMode() {
+		return func() {}
+	}
+	c.pagerMu.Lock()
+	c.pager = &pager{c: c, limit: pageSize(c)}
+	c.pagerMu.Unlock()
+	return func() {
+		c.pagerMu.Lock()
+		c.pager = nil
+		c.pagerMu.Unlock()
+	}
+}
+
+func (c *Client) activePager() *pager {
+	c.pagerMu.Lock()
+	defer c.pagerMu.Unlock()
+	return c.pager
+}
+
+// rows returns the number of terminal rows needed to display line
+func rows(line string, width int) int {
+	n := utf8.RuneCountInString(strings.TrimRight(line, "\r\n"))
+	if n <= width || width <= 0 {
+		return 1
+	}
+	return (n + width - 1) / width
+}
+
+func (p *pager) write(text string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.quit {
+		return false
+	}
+	width, _ := p.c.WindowSize()
+	for len(text) > 0 {
+		// only ask the user once there is actually more output
+		if p.lines >= p.limit && !p.more() {
+			p.quit = true
+			return false
+		}
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			return p.c.writeRaw(text) // incomplete line, this doesn't count yet
+		}
+		if !p.c.writeRaw(text[:i+1]) {
+			return false
+		}
+		p.lines += rows(text[:i], width)
+		text = text[i+1:]
+	}
+	return true
+}
+
+// more shows the --More-- prompt and waits for the user. It returns false
+// if the user doesn't want to see any more output.
+func (p *pager) more() bool {
+	p.c.writeRaw(p.c.Style("reverse", "--More--"))
+	p.c.setCharMode(true)
+	line, ok := p.c.ReadLine()
+	charMode := p.c.inCharMode()
+	p.c.setCharMode(false)
+	switch {
+	case charMode && p.c.Has(CapCursor):
+		p.c.writeRaw("\r\x1b[2K")
+	case charMode:
+		p.c.writeRaw("\r        \r")
+	case p.c.Has(CapCursor): // remove the prompt as well as the echoed input
+		p.c.writeRaw("\x1b[1A\r\x1b[2K")
+	default:
+		p.c.writeRaw("\r")
+	}
+	if !ok {
+		return false
+	}
+	switch strings.ToLower(strings.TrimRight(line, "\r\n")) {
+	case "q", "quit":
+		return false
+	case "":
+		p.limit = p.lines + 1 // enter shows one more line
+	default:
+		p.limit = p.lines + pageSize(p.c) // space (or anything else) shows the next page
+	}
+	return true
+}
+
+// Paging returns true if the output of commands will be paged for this
+// session.
+func (c *Client) Paging() bool {
+	c.termMu.Lock()
+	defer c.termMu.Unlock()
+	return c.paging
+}
+
+// SetPaging enables or disables the pager for this session. If paging is
+// enabled the output of commands is paused whenever it exceeds the height of
+// the user's terminal and a --More-- prompt is shown. The user may then hit
+// space to see the next page, enter to see one more line or q to skip the rest
+// of the output. While the prompt is shown the client is switched to
+// character mode, clients which don't support this (i.e. the telnet options
+// ECHO and SGA) have to confirm the key using enter. Regardless of this setting
+// the pager may be used for a single command by appending "| more" to it.
+func (c *Client) SetPaging(enabled bool) {
+	c.termMu.Lock()
+	defer c.termMu.Unlock()
+	c.paging = enabled
+}
+
+// SetPaging sets the default paging setting for new client sessions, see
+// Client.SetPaging. Paging is disabled by default.
+func (s *Server) SetPaging(enabled bool) {
+	s.paging = enabled
+}
+
+// PagingCmd is a telgo command which lets the user turn paging on or off for
+// the current session. Without arguments it shows the current setting.
+func PagingCmd(c *Client, args []string) bool {
+	switch {
+	case len(args) == 1:
+	case len(args) == 2 && (args[1] == "on" || args[1] == "off"):
+		c.SetPaging(args[1] == "on")
+	default:
+		c.Errorf("usage: %s [on|off]", args[0])
+		return false
+	}
+	if c.Paging() {
+		c.Sayln("paging is on")
+	} else {
+		c.Sayln("paging is off")
+	}
+	return false
+}
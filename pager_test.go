@@ -0,0 +1,91 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStripMore(t *testing.T) {
+	for _, tt := range []struct {
+		line  string
+		args  []string
+		found bool
+	}{
+		{`ls | more`, []string{"ls"}, true},
+		{`ls -l |more  `, []string{"ls", "-l"}, true},
+		{`ls|more`, []string{"ls|more"}, false},
+		{`| more`, []string{}, true},
+		{`echo more`, []string{"echo", "more"}, false},
+		{`echo "|" more`, []string{"echo", "|", "more"}, false},
+		{`echo "| more"`, []string{"echo", "| more"}, false},
+		{`echo "|more"`, []string{"echo", "|more"}, false},
+		{`echo a "|" "more"`, []string{"echo", "a", "|", "more"}, false},
+	} {
+		args, err := splitCmdArguments(tt.line)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.line, err)
+		}
+		got, found := stripMore(tt.line, args)
+		if found != tt.found || !reflect.DeepEqual(got, tt.args) {
+			t.Errorf("%s: expected %q/%v, got %q/%v", tt.line, tt.args, tt.found, got, found)
+		}
+	}
+}
+
+// TestCharModeAfterClose makes sure leaving character mode, which the pager
+// does after the --More-- prompt, doesn't block once the session is closed.
+func TestCharModeAfterClose(t *testing.T) {
+	s, err := NewServerFromListener(nil, "> ", CmdList{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client := net.Pipe()
+	defer client.Close()
+	c := newClient(server, s, nil, nil)
+	for _, opt := range supportedLocalOptions {
+		c.localOpts[opt].state = qYes
+	}
+	close(c.quitSend) // there is no sending go routine anymore
+
+	done := make(chan bool)
+	go func() {
+		c.setCharMode(false)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("setCharMode blocked after the session has been closed")
+	}
+}
@@ -0,0 +1,277 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+// Package profile contains telgo commands to take profiles and execution
+// traces of the running application. Profiles are either written to a
+// directory or, if no directory is configured, streamed base64-encoded to the
+// client.
+package profile
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spreadspace/telgo"
+)
+
+const (
+	b64LineLength = 76
+)
+
+// Profiler contains the configuration needed by the profiling commands.
+type Profiler struct {
+	dir string
+}
+
+// New creates a new profiler. If dir is not empty profiles will be stored as
+// files inside this directory, otherwise they are sent base64-encoded to the
+// client.
+func New(dir string) *Profiler {
+	return &Profiler{dir: dir}
+}
+
+// Register adds all commands of the profiler to cmds using their default
+// names: cpuprofile, profile, profilerate and trace.
+func (p *Profiler) Register(cmds telgo.CmdList) {
+	cmds["cpuprofile"] = p.CPUProfile
+	cmds["profile"] = p.Profile
+	cmds["profilerate"] = p.ProfileRate
+	cmds["trace"] = p.Trace
+}
+
+// Register is a shortcut for New(dir).Register(cmds).
+func Register(cmds telgo.CmdList, dir string) {
+	New(dir).Register(cmds)
+}
+
+// create creates a new file for a profile of the given kind. Existing files
+// are never overwritten, if there already is a profile of the same kind taken
+// within the same second a counter is appended to the name.
+func (p *Profiler) create(kind string) (*os.File, error) {
+	ext := ".pprof"
+	if kind == "trace" {
+		ext = ".out"
+	}
+	base := filepath.Join(p.dir, fmt.Sprintf("%s-%s", kind, time.Now().Format("20060102-150405")))
+	for i := 0; ; i++ {
+		name := base + ext
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+}
+
+func (p *Profiler) output(c *telgo.Client, kind string, data []byte) {
+	if p.dir != "" {
+		f, err := p.create(kind)
+		if err != nil {
+			c.Errorf("failed to write %s profile: %v", kind, err)
+			return
+		}
+		name := f.Name()
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			c.Errorf("failed to write %s profile: %v", kind, err)
+			return
+		}
+		c.Sayln("%s profile written to %s (%d bytes)", kind, name, len(data))
+		return
+	}
+
+	enc := base64.StdEncoding.EncodeToString(data)
+	c.Sayln("-----BEGIN %s PROFILE-----", strings.ToUpper(kind))
+	for len(enc) > 0 {
+		n := b64LineLength
+		if n > len(enc) {
+			n = len(enc)
+		}
+		if !c.Sayln("%s", enc[:n]) {
+			return
+		}
+		enc = enc[n:]
+	}
+	c.Sayln("-----END %s PROFILE-----", strings.ToUpper(kind))
+}
+
+// wait waits for d to pass. It returns false if the user canceled the command.
+func wait(c *telgo.Client, d time.Duration) bool {
+	select {
+	case <-c.Cancel:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func parseDuration(c *telgo.Client, args []string) (time.Duration, bool) {
+	if len(args) != 2 {
+		c.Errorf("usage: %s <seconds>", args[0])
+		return 0, false
+	}
+	s, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil || s == 0 {
+		c.Errorf("'%s' is not a valid duration: must be a positive integer", args[1])
+		return 0, false
+	}
+	return time.Duration(s) * time.Second, true
+}
+
+// CPUProfile takes a CPU profile for the number of seconds supplied as
+// argument. The command may be aborted using Ctrl-C in which case the
+// profile will be discarded.
+func (p *Profiler) CPUProfile(c *telgo.Client, args []string) bool {
+	d, ok := parseDuration(c, args)
+	if !ok {
+		return false
+	}
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		c.Errorf("can't start CPU profile: %v", err)
+		return false
+	}
+	c.Sayln("taking CPU profile for %s (type Ctrl-C to abort)", d)
+	ok = wait(c, d)
+	pprof.StopCPUProfile()
+	if !ok {
+		c.Sayln("aborted.")
+		return false
+	}
+	p.output(c, "cpu", buf.Bytes())
+	return false
+}
+
+// Trace takes an execution trace for the number of seconds supplied as
+// argument. The command may be aborted using Ctrl-C in which case the trace
+// will be discarded.
+func (p *Profiler) Trace(c *telgo.Client, args []string) bool {
+	d, ok := parseDuration(c, args)
+	if !ok {
+		return false
+	}
+	var buf bytes.Buffer
+	if err := trace.Start(&buf); err != nil {
+		c.Errorf("can't start execution trace: %v", err)
+		return false
+	}
+	c.Sayln("tracing for %s (type Ctrl-C to abort)", d)
+	ok = wait(c, d)
+	trace.Stop()
+	if !ok {
+		c.Sayln("aborted.")
+		return false
+	}
+	p.output(c, "trace", buf.Bytes())
+	return false
+}
+
+// Profile writes one of the profiles known to runtime/pprof, for example
+// heap, allocs, block, mutex, goroutine or threadcreate. An optional second
+// argument sets the debug level as understood by pprof.Profile.WriteTo.
+// Without arguments the available profiles are listed.
+func (p *Profiler) Profile(c *telgo.Client, args []string) bool {
+	if len(args) < 2 {
+		for _, prof := range pprof.Profiles() {
+			c.Sayln("%-14s %d", prof.Name(), prof.Count())
+		}
+		return false
+	}
+	if len(args) > 3 {
+		c.Errorf("usage: %s [<name> [<debug>]]", args[0])
+		return false
+	}
+	prof := pprof.Lookup(args[1])
+	if prof == nil {
+		c.Errorf("unknown profile '%s'", args[1])
+		return false
+	}
+	debug := 0
+	if len(args) == 3 {
+		var err error
+		if debug, err = strconv.Atoi(args[2]); err != nil || debug < 0 {
+			c.Errorf("'%s' is not a valid debug level", args[2])
+			return false
+		}
+	}
+	if args[1] == "heap" || args[1] == "allocs" {
+		runtime.GC() // get up-to-date statistics
+	}
+	var buf bytes.Buffer
+	if err := prof.WriteTo(&buf, debug); err != nil {
+		c.Errorf("can't write %s profile: %v", args[1], err)
+		return false
+	}
+	if debug > 0 {
+		c.Write(buf.Bytes()) // this is human readable
+		return false
+	}
+	p.output(c, args[1], buf.Bytes())
+	return false
+}
+
+// ProfileRate sets the sampling rate of the block or mutex profile. Both are
+// disabled by default. See runtime.SetBlockProfileRate and
+// runtime.SetMutexProfileFraction for details.
+func (p *Profiler) ProfileRate(c *telgo.Client, args []string) bool {
+	if len(args) != 3 {
+		c.Errorf("usage: %s (block|mutex) <rate>", args[0])
+		return false
+	}
+	rate, err := strconv.Atoi(args[2])
+	if err != nil || rate < 0 {
+		c.Errorf("'%s' is not a valid rate: must be a non-negative integer", args[2])
+		return false
+	}
+	switch args[1] {
+	case "block":
+		runtime.SetBlockProfileRate(rate)
+		c.Sayln("block profile rate set to %d", rate)
+	case "mutex":
+		old := runtime.SetMutexProfileFraction(rate)
+		c.Sayln("mutex profile fraction: %d -> %d", old, rate)
+	default:
+		c.Errorf("unknown profile '%s', must be block or mutex", args[1])
+	}
+	return false
+}
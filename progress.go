@@ -0,0 +1,335 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	progressInterval = 100 * time.Millisecond
+)
+
+// widget is an output element which redraws itself in place, like progress
+// bars and spinners. Widgets are finished when the command which created
+// them returns or gets canceled.
+type widget interface {
+	finish(aborted bool)
+}
+
+func (c *Client) addWidget(w widget) {
+	c.widgetMu.Lock()
+	c.widgets = append(c.widgets, w)
+	c.widgetMu.Unlock()
+
+	ctx := c.Context()
+	go func() {
+		<-ctx.Done()
+		w.finish(canceled(ctx))
+	}()
+}
+
+func (c *Client) removeWidget(w widget) {
+	c.widgetMu.Lock()
+	defer c.widgetMu.Unlock()
+	for i, x := range c.widgets {
+		if x == w {
+			c.widgets = append(c.widgets[:i], c.widgets[i+1:]...)
+			return
+		}
+	}
+}
+
+// finishWidgets finishes all widgets the command forgot about. If the
+// command got canceled they are marked as aborted.
+func (c *Client) finishWidgets() {
+	c.widgetMu.Lock()
+	widgets := c.widgets
+	c.widgets = nil
+	c.widgetMu.Unlock()
+	aborted := canceled(c.Context())
+	for _, w := range widgets {
+		w.finish(aborted)
+	}
+}
+
+// dumbTerminal returns true if the output shouldn't even be redrawn using a
+// carriage return.
+func (c *Client) dumbTerminal() bool {
+	return strings.EqualFold(c.TerminalType(), "dumb")
+}
+
+// redrawLine replaces the current line with text.
+func (c *Client) redrawLine(text string, lastlen int) bool {
+	if c.Has(CapCursor) {
+		return c.WriteString("\r\x1b[K" + text)
+	}
+	if l := utf8.RuneCountInString(text); l < lastlen { // overwrite leftovers of the last line
+		text += strings.Repeat(" ", lastlen-l) + strings.Repeat("\b", lastlen-l)
+	}
+	return c.WriteString("\r" + text)
+}
+
+// Progress is a progress bar which redraws itself in place. Use
+// Client.Progress to create it. The progress bar is drawn for the first time
+// when the label or the progress is set. Redraws are throttled to at most 10
+// per second. On dumb terminals a new line is printed for every 10 percent.
+// The progress bar gets finished automatically when the user cancels the
+// command or the command returns. Progress bars aren't shown in machine mode.
+type Progress struct {
+	mu       sync.Mutex
+	c        *Client
+	label    string
+	total    int64
+	current  int64
+	last     time.Time
+	lasttext string
+	lastStep int64
+	finished bool
+}
+
+// Progress creates a new progress bar for a task consisting of total units
+// of work.
+func (c *Client) Progress(total int64) *Progress {
+	p := &Progress{c: c, total: total, lastStep: -1, finished: c.MachineMode()}
+	c.addWidget(p)
+	return p
+}
+
+// SetLabel sets the text shown in front of the progress bar.
+func (p *Progress) SetLabel(label string) *Progress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.label = label
+	p.draw(true)
+	return p
+}
+
+// Set sets the amount of work done so far.
+func (p *Progress) Set(current int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current = current
+	p.draw(false)
+}
+
+// Add adds n to the amount of work done so far.
+func (p *Progress) Add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current += n
+	p.draw(false)
+}
+
+// Done sets the progress to 100% and finishes the progress bar.
+func (p *Progress) Done() {
+	p.mu.Lock()
+	p.current = p.total
+	p.mu.Unlock()
+	p.finish(false)
+	p.c.removeWidget(p)
+}
+
+func (p *Progress) percent() float64 {
+	if p.total <= 0 {
+		return 0
+	}
+	pct := float64(p.current) / float64(p.total) * 100.0
+	if pct > 100 {
+		pct = 100
+	}
+	return pct
+}
+
+func (p *Progress) text() string {
+	pct := p.percent()
+	label := p.label
+	if label != "" {
+		label += " "
+	}
+	width, _ := p.c.WindowSize()
+	barwidth := width - utf8.RuneCountInString(label) - 10
+	if barwidth < 5 {
+		return fmt.Sprintf("%s%5.1f%%", label, pct)
+	}
+	if barwidth > 50 {
+		barwidth = 50
+	}
+	full, empty := "#", "-"
+	if p.c.Has(CapUnicode) {
+		full, empty = "█", "░"
+	}
+	n := int(pct / 100 * float64(barwidth))
+	return fmt.Sprintf("%s[%s%s] %5.1f%%", label, strings.Repeat(full, n), strings.Repeat(empty, barwidth-n), pct)
+}
+
+// draw must be called with p.mu held
+func (p *Progress) draw(force bool) {
+	if p.finished {
+		return
+	}
+	if p.c.dumbTerminal() {
+		if step := int64(p.percent() / 10); step > p.lastStep {
+			p.lastStep = step
+			p.c.Sayln("%s", p.text())
+		}
+		return
+	}
+	if !force && time.Since(p.last) < progressInterval {
+		return
+	}
+	text := p.text()
+	if text == p.lasttext {
+		return
+	}
+	p.last = time.Now()
+	p.c.redrawLine(text, utf8.RuneCountInString(p.lasttext))
+	p.lasttext = text
+}
+
+func (p *Progress) finish(aborted bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.finished {
+		return
+	}
+	if aborted {
+		if !p.c.dumbTerminal() && p.lasttext != "" {
+			p.c.WriteString(" aborted.\r\n")
+		} else {
+			p.c.Sayln("aborted.")
+		}
+	} else {
+		p.draw(true)
+		if !p.c.dumbTerminal() {
+			p.c.WriteString("\r\n")
+		}
+	}
+	p.finished = true
+}
+
+// Spinner shows an animation while a task of unknown length is running. Use
+// Client.Spinner to create and start it. On dumb terminals the label is
+// printed once followed by the result. The spinner gets stopped automatically
+// when the user cancels the command or the command returns. Spinners aren't
+// shown in machine mode.
+type Spinner struct {
+	mu       sync.Mutex
+	c        *Client
+	label    string
+	frames   []string
+	frame    int
+	lastlen  int
+	finished bool
+	stop     chan bool
+	stopped  chan bool
+	done     chan bool
+}
+
+// Spinner creates a new spinner showing label and starts it.
+func (c *Client) Spinner(label string) *Spinner {
+	s := &Spinner{c: c, label: label, stop: make(chan bool), stopped: make(chan bool), done: make(chan bool)}
+	s.frames = []string{"|", "/", "-", "\\"}
+	if c.Has(CapUnicode) {
+		s.frames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+	}
+	if c.MachineMode() {
+		s.finished = true
+		close(s.stopped)
+		close(s.done)
+	} else if c.dumbTerminal() {
+		c.Say("%s ...", label)
+		close(s.stopped)
+	} else {
+		s.draw()
+		go s.run()
+	}
+	c.addWidget(s)
+	return s
+}
+
+func (s *Spinner) run() {
+	defer close(s.stopped)
+	t := time.NewTicker(progressInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+			s.mu.Lock()
+			s.frame = (s.frame + 1) % len(s.frames)
+			s.draw()
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *Spinner) draw() {
+	text := s.label + " " + s.frames[s.frame]
+	s.c.redrawLine(text, s.lastlen)
+	s.lastlen = utf8.RuneCountInString(text)
+}
+
+// Stop stops the spinner and replaces the animation by "done.".
+func (s *Spinner) Stop() {
+	s.finish(false)
+	s.c.removeWidget(s)
+}
+
+func (s *Spinner) finish(aborted bool) {
+	s.mu.Lock()
+	if s.finished {
+		s.mu.Unlock()
+		<-s.done // wait for the result to be printed
+		return
+	}
+	s.finished = true
+	s.mu.Unlock()
+	defer close(s.done)
+	close(s.stop)
+	<-s.stopped
+
+	result := "done."
+	if aborted {
+		result = "aborted."
+	}
+	if s.c.dumbTerminal() {
+		s.c.Sayln(" %s", result)
+		return
+	}
+	text := s.label + " " + result
+	s.c.redrawLine(text, s.lastlen)
+	s.c.WriteString("\r\n")
+}
@@ -0,0 +1,224 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// time a trusted proxy has to send the PROXY protocol header
+	proxyHeaderTimeout = 5 * time.Second
+	// maximum length of a PROXY protocol v1 header including CRLF
+	proxyV1MaxLength = 107
+)
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// SetProxyProtocol enables the PROXY protocol (version 1 and 2) as used by
+// HAProxy and other load balancers for connections accepted by Run.
+// Connections from the addresses in trusted, which may be IP addresses or
+// networks in CIDR notation, must start with a PROXY protocol header. The
+// client address from the header is then used as remote address of the
+// connection, see Client.Conn, in all log messages, audit records and
+// recordings. The address of the proxy is logged as well. Headers which
+// don't carry a TCP address, like the LOCAL command or UNIX sockets, leave
+// the address of the proxy in place. Connections from other addresses are
+// handled as usual. Calling SetProxyProtocol without any address disables
+// the PROXY protocol.
+func (s *Server) SetProxyProtocol(trusted ...string) error {
+	var nets []*net.IPNet
+	for _, t := range trusted {
+		if !strings.Contains(t, "/") {
+			ip := net.ParseIP(t)
+			if ip == nil {
+				return fmt.Errorf("telgo: invalid proxy address: %q", t)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(t)
+		if err != nil {
+			return fmt.Errorf("telgo: invalid proxy network: %v", err)
+		}
+		nets = append(nets, n)
+	}
+	s.trustedProxies = nets
+	return nil
+}
+
+func (s *Server) isTrustedProxy(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range s.trustedProxies {
+		if n.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn is a connection from a proxy using the PROXY protocol
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+// newProxyConn reads the PROXY protocol header from conn
+func newProxyConn(conn net.Conn) (*proxyConn, error) {
+	c := &proxyConn{Conn: conn, r: bufio.NewReader(conn)}
+	if err := conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout)); err != nil {
+		return nil, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	// only peek as much as the shortest header is long, the proxy might wait
+	// for the server before sending anything else
+	prefix, err := c.r.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(prefix, proxyV1Prefix):
+		err = c.readV1()
+	case bytes.HasPrefix(proxyV2Signature, prefix):
+		var sig []byte
+		if sig, err = c.r.Peek(len(proxyV2Signature)); err == nil {
+			if bytes.Equal(sig, proxyV2Signature) {
+				err = c.readV2()
+			} else {
+				err = errors.New("missing PROXY protocol header")
+			}
+		}
+	default:
+		err = errors.New("missing PROXY protocol header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *proxyConn) readV1() error {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength {
+			return errors.New("PROXY protocol header is too long")
+		}
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil // the proxy doesn't know the address, use the proxy's one
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("invalid PROXY protocol header: %q", strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return fmt.Errorf("invalid PROXY protocol source address: %s:%s", fields[2], fields[4])
+	}
+	c.remote = &net.TCPAddr{IP: ip, Port: int(port)}
+	return nil
+}
+
+func (c *proxyConn) readV2() error {
+	var hdr [16]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[12]>>4 != 2 {
+		return fmt.Errorf("unsupported PROXY protocol version: %d", hdr[12]>>4)
+	}
+	if cmd := hdr[12] & 0x0f; cmd > 1 {
+		return fmt.Errorf("unsupported PROXY protocol command: %d", cmd)
+	}
+	if hdr[13]>>4 > 3 || hdr[13]&0x0f > 2 {
+		return fmt.Errorf("unsupported PROXY protocol address family: 0x%02x", hdr[13])
+	}
+	data := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return err
+	}
+	if hdr[12]&0x0f == 0 {
+		return nil // LOCAL command, e.g. health checks of the proxy itself
+	}
+	switch hdr[13] {
+	case 0x11: // TCP over IPv4
+		if len(data) < 12 {
+			return errors.New("PROXY protocol address block is too short")
+		}
+		c.remote = &net.TCPAddr{IP: net.IP(data[0:4]), Port: int(binary.BigEndian.Uint16(data[8:10]))}
+	case 0x21: // TCP over IPv6
+		if len(data) < 36 {
+			return errors.New("PROXY protocol address block is too short")
+		}
+		c.remote = &net.TCPAddr{IP: net.IP(data[0:16]), Port: int(binary.BigEndian.Uint16(data[32:34]))}
+	}
+	// UDP, UNIX sockets and unspecified addresses are ignored and the
+	// proxy's address is used
+	return nil
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// RemoteAddr returns the address of the client as sent by the proxy.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.remote == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.remote
+}
@@ -0,0 +1,172 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func proxyV2(cmd, family byte, addr []byte) []byte {
+	hdr := append([]byte(nil), proxyV2Signature...)
+	hdr = append(hdr, 0x20|cmd, family, 0, 0)
+	binary.BigEndian.PutUint16(hdr[14:16], uint16(len(addr)))
+	return append(hdr, addr...)
+}
+
+func TestProxyHeader(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0x30, 0x39, 0x00, 0x17}
+	ipv6 := make([]byte, 36)
+	copy(ipv6, net.ParseIP("2001:db8::1"))
+	copy(ipv6[16:], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(ipv6[32:34], 4711)
+	binary.BigEndian.PutUint16(ipv6[34:36], 23)
+	unix := make([]byte, 216)
+	copy(unix, "/run/client.sock")
+
+	tests := []struct {
+		name   string
+		header []byte
+		remote string // empty if the address of the proxy is kept
+		err    string // empty if the header is valid
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 23\r\n"), "192.0.2.1:12345", ""},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 4711 23\r\n"), "[2001:db8::1]:4711", ""},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "", ""},
+		{"v1 unknown with addresses", []byte("PROXY UNKNOWN 192.0.2.1 198.51.100.1 12345 23\r\n"), "", ""},
+		{"v1 unix", []byte("PROXY UNIX /run/client.sock /run/server.sock 0 0\r\n"), "", "invalid PROXY protocol header"},
+		{"v1 missing fields", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345\r\n"), "", "invalid PROXY protocol header"},
+		{"v1 invalid address", []byte("PROXY TCP4 192.0.2 198.51.100.1 12345 23\r\n"), "", "invalid PROXY protocol source address"},
+		{"v1 invalid port", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 123456 23\r\n"), "", "invalid PROXY protocol source address"},
+		{"v1 too long", []byte("PROXY TCP6 " + strings.Repeat("f", 120) + "\r\n"), "", "too long"},
+		{"v1 truncated", []byte("PROXY TCP4 192.0.2.1"), "", "EOF"},
+		{"v2 tcp4", proxyV2(1, 0x11, ipv4), "192.0.2.1:12345", ""},
+		{"v2 tcp6", proxyV2(1, 0x21, ipv6), "[2001:db8::1]:4711", ""},
+		{"v2 tcp4 with tlvs", proxyV2(1, 0x11, append(ipv4, 0x04, 0x00, 0x01, 0x00)), "192.0.2.1:12345", ""},
+		{"v2 local", proxyV2(0, 0x11, ipv4), "", ""},
+		{"v2 local unspec", proxyV2(0, 0x00, nil), "", ""},
+		{"v2 udp4", proxyV2(1, 0x12, ipv4), "", ""},
+		{"v2 unix", proxyV2(1, 0x31, unix), "", ""},
+		{"v2 unknown family", proxyV2(1, 0x41, ipv4), "", "unsupported PROXY protocol address family"},
+		{"v2 unknown transport", proxyV2(1, 0x13, ipv4), "", "unsupported PROXY protocol address family"},
+		{"v2 unknown command", proxyV2(2, 0x11, ipv4), "", "unsupported PROXY protocol command"},
+		{"v2 wrong version", append(append([]byte(nil), proxyV2Signature...), 0x11, 0x11, 0, 0), "", "unsupported PROXY protocol version"},
+		{"v2 tcp4 too short", proxyV2(1, 0x11, ipv4[:8]), "", "too short"},
+		{"v2 tcp6 too short", proxyV2(1, 0x21, ipv4), "", "too short"},
+		{"v2 truncated header", proxyV2(1, 0x11, ipv4)[:14], "", "EOF"},
+		{"v2 length exceeds data", proxyV2(1, 0x11, ipv4)[:20], "", "EOF"},
+		{"v2 oversized length", append(proxyV2(1, 0x11, nil)[:14], 0xff, 0xff), "", "EOF"},
+		{"no header", []byte("hello world\r\n"), "", "missing PROXY protocol header"},
+		{"short line", []byte("hi\r\n"), "", "missing PROXY protocol header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			go func() {
+				client.Write(append(tt.header, "hello"...))
+				client.Close()
+			}()
+
+			c, err := newProxyConn(server)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := tt.remote
+			if want == "" {
+				want = server.RemoteAddr().String()
+			}
+			if got := c.RemoteAddr().String(); got != want {
+				t.Errorf("expected remote address %s, got %s", want, got)
+			}
+			data, err := io.ReadAll(c)
+			if err != nil || string(data) != "hello" {
+				t.Errorf("expected the data after the header, got %q (%v)", data, err)
+			}
+		})
+	}
+	for _, tt := range tests {
+		if tt.err != "" {
+			continue
+		}
+		// the client waits for the server before sending anything else
+		t.Run(tt.name+" header only", func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			defer client.Close()
+			go client.Write(tt.header)
+
+			c, err := newProxyConn(server)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := tt.remote
+			if want == "" {
+				want = server.RemoteAddr().String()
+			}
+			if got := c.RemoteAddr().String(); got != want {
+				t.Errorf("expected remote address %s, got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestSetProxyProtocol(t *testing.T) {
+	s := &Server{}
+	if err := s.SetProxyProtocol("192.0.2.1", "2001:db8::/32"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for addr, trusted := range map[string]bool{
+		"192.0.2.1:1234":       true,
+		"192.0.2.2:1234":       false,
+		"[2001:db8::1]:1234":   true,
+		"[2001:db9::1]:1234":   false,
+		"[::ffff:192.0.2.1]:1": true,
+	} {
+		a, _ := net.ResolveTCPAddr("tcp", addr)
+		if got := s.isTrustedProxy(a); got != trusted {
+			t.Errorf("%s: expected trusted=%v, got %v", addr, trusted, got)
+		}
+	}
+	for _, invalid := range []string{"192.0.2", "192.0.2.0/33", "proxy.example.com"} {
+		if err := s.SetProxyProtocol(invalid); err == nil {
+			t.Errorf("%s: expected an error", invalid)
+		}
+	}
+}
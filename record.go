@@ -0,0 +1,330 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	castVersion   = 2
+	replayMaxIdle = 2 * time.Second
+)
+
+// castHeader is the first line of an asciicast v2 file.
+type castHeader struct {
+	Version   int    `json:"version"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Timestamp int64  `json:"timestamp"`
+	Title     string `json:"title,omitempty"`
+}
+
+// recorder writes the input and output streams of a client session to a
+// file using the asciicast v2 format. The header contains the size of the
+// terminal which isn't known before the telnet options have been negotiated,
+// see begin, events are held back until then. All methods may be called on a
+// nil recorder in which case they do nothing.
+type recorder struct {
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	start   time.Time
+	hdr     castHeader
+	started bool         // the header has been written
+	pending bytes.Buffer // events recorded before the header has been written
+}
+
+func newRecorder(dir string, c *Client) (*recorder, error) {
+	r := &recorder{start: time.Now()}
+	name := fmt.Sprintf("%s-%d.cast", r.start.Format("20060102-150405"), c.id)
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	r.f = f
+	r.w = bufio.NewWriter(f)
+
+	width, height := c.WindowSize()
+	r.hdr = castHeader{
+		Version:   castVersion,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+		Title:     fmt.Sprintf("telgo session %d from %s", c.id, c.Conn.RemoteAddr()),
+	}
+	return r, nil
+}
+
+// begin writes the header followed by the events recorded so far. It is
+// called once the window size of the client is known or the client has
+// shown that it won't tell.
+func (r *recorder) begin() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writeHeader()
+}
+
+// writeHeader must be called with r.mu held.
+func (r *recorder) writeHeader() {
+	if r.started {
+		return
+	}
+	r.started = true
+	if line, err := json.Marshal(r.hdr); err == nil {
+		r.w.Write(append(line, '\n'))
+	}
+	r.w.Write(r.pending.Bytes())
+	r.pending.Reset()
+	r.w.Flush()
+}
+
+func (r *recorder) event(code string, data string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := time.Since(r.start).Seconds()
+	line, err := json.Marshal([]interface{}{t, code, data})
+	if err != nil {
+		return
+	}
+	if !r.started {
+		r.pending.Write(append(line, '\n'))
+		return
+	}
+	r.w.Write(append(line, '\n'))
+	r.w.Flush()
+}
+
+// output records data sent to the client. Escaped IAC bytes are unescaped
+// again so the recording contains exactly what the user got to see.
+func (r *recorder) output(data []byte) {
+	r.event("o", string(bytes.Replace(data, []byte{bIAC, bIAC}, []byte{bIAC}, -1)))
+}
+
+// input records data received from the client. Sensitive arguments must
+// have been redacted already.
+func (r *recorder) input(data string) {
+	r.event("i", data)
+}
+
+// resize records a change of the terminal size. Changes before the header
+// has been written end up in the header.
+func (r *recorder) resize(width, height int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	if !r.started {
+		r.hdr.Width, r.hdr.Height = width, height
+		r.mu.Unlock()
+		return
+	}
+	r.mu.Unlock()
+	r.event("r", fmt.Sprintf("%dx%d", width, height))
+}
+
+func (r *recorder) close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writeHeader()
+	r.w.Flush()
+	r.f.Close()
+}
+
+// SetRecordDir enables recording of all client sessions. For every client a
+// file in the asciicast v2 format will be created inside dir which contains
+// the input and output streams of the session including timestamps.
+// Arguments marked using SetSensitiveArgs are redacted from the input stream.
+// Set dir to the empty string to disable recording for new sessions.
+func (s *Server) SetRecordDir(dir string) {
+	s.recordDir = dir
+}
+
+// ReplayRecording sends the output stream of an asciicast v2 recording read
+// from r to the client c. Telnet clients echo the lines typed by the user
+// locally, which means they are not part of the output stream. Therefore the
+// input stream is replayed as well, without control characters. The timing of
+// the recording is preserved, speed may be used to speed up (> 1) or slow down
+// (< 1) the replay. Idle periods longer than 2 seconds will be shortened. The
+// recording is written to the connection as it is, bypassing the pager. It
+// can't be replayed to clients in machine mode. The replay will be aborted if
+// the user hits Ctrl-C or the client connection gets closed.
+func ReplayRecording(c *Client, r io.Reader, speed float64) error {
+	if c.MachineMode() {
+		return fmt.Errorf("recordings can't be replayed in machine mode")
+	}
+	if speed <= 0 {
+		speed = 1
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("recording is empty")
+	}
+	var hdr castHeader
+	if err := json.Unmarshal(scanner.Bytes(), &hdr); err != nil {
+		return fmt.Errorf("invalid header: %v", err)
+	}
+	if hdr.Version != castVersion {
+		return fmt.Errorf("unsupported asciicast version %d", hdr.Version)
+	}
+
+	last := 0.0
+	for scanner.Scan() {
+		var ev []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return fmt.Errorf("invalid event: %v", err)
+		}
+		if len(ev) != 3 {
+			return fmt.Errorf("invalid event: expected 3 elements, got %d", len(ev))
+		}
+		t, ok1 := ev[0].(float64)
+		code, ok2 := ev[1].(string)
+		data, ok3 := ev[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return fmt.Errorf("invalid event: %s", scanner.Text())
+		}
+		switch code {
+		case "o":
+		case "i":
+			if data = replayInput(data); data == "" {
+				continue
+			}
+		default:
+			continue
+		}
+
+		delay := time.Duration((t - last) / speed * float64(time.Second))
+		if delay > replayMaxIdle {
+			delay = replayMaxIdle
+		}
+		last = t
+		if delay > 0 {
+			select {
+			case <-c.Cancel:
+				return fmt.Errorf("aborted")
+			case <-time.After(delay):
+			}
+		}
+		if !c.writeRaw(data) {
+			return fmt.Errorf("client connection closed")
+		}
+	}
+	return scanner.Err()
+}
+
+// replayInput returns the input as the user got to see it while typing
+func replayInput(data string) string {
+	if data == "\x03" {
+		return "^C"
+	}
+	return strings.Map(func(r rune) rune {
+		if r < ' ' && r != '\r' && r != '\n' {
+			return -1
+		}
+		return r
+	}, data)
+}
+
+// ReplayCmd returns a telgo command which can be used to replay recordings
+// found in dir to the user. The command expects the file name of the
+// recording and optionally the replay speed as arguments. If it is called
+// without arguments it lists all available recordings.
+func ReplayCmd(dir string) Cmd {
+	return func(c *Client, args []string) bool {
+		if len(args) < 2 {
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				c.Errorf("can't read recordings: %v", err)
+				return false
+			}
+			var names []string
+			for _, f := range files {
+				if strings.HasSuffix(f.Name(), ".cast") {
+					names = append(names, f.Name())
+				}
+			}
+			c.SayColumns(names)
+			return false
+		}
+		if len(args) > 3 {
+			c.Errorf("usage: %s [<recording> [<speed>]]", args[0])
+			return false
+		}
+		if filepath.Base(args[1]) != args[1] {
+			c.Errorf("'%s' is not a valid recording name", args[1])
+			return false
+		}
+		speed := 1.0
+		if len(args) == 3 {
+			var err error
+			if speed, err = strconv.ParseFloat(args[2], 64); err != nil || speed <= 0 {
+				c.Errorf("'%s' is not a valid speed: must be a positive number", args[2])
+				return false
+			}
+		}
+
+		f, err := os.Open(filepath.Join(dir, args[1]))
+		if err != nil {
+			c.Errorf("can't open recording: %v", err)
+			return false
+		}
+		defer f.Close()
+		if err = ReplayRecording(c, f, speed); err != nil {
+			c.Say("\r\n")
+			c.Errorf("replay failed: %v", err)
+		}
+		return false
+	}
+}
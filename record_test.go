@@ -0,0 +1,107 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spreadspace/telgo"
+	"github.com/spreadspace/telgo/telgotest"
+)
+
+func TestRecordingHeader(t *testing.T) {
+	dir := t.TempDir()
+	h := telgotest.New(t, "> ", telgo.CmdList{}, nil)
+	h.Server.SetRecordDir(dir)
+	s := h.Connect()
+	s.SetWindowSize(132, 43)
+	s.ExpectPrompt()
+	s.SetWindowSize(100, 30)
+	s.Run("")
+	s.Close()
+
+	var data []byte
+	for end := time.Now().Add(telgotest.DefaultTimeout); ; {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.cast"))
+		if len(files) == 1 {
+			data, _ = os.ReadFile(files[0])
+			if strings.Count(string(data), "\n") >= 2 {
+				break
+			}
+		}
+		if time.Now().After(end) {
+			t.Fatalf("no recording found")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	scanner.Scan()
+	var hdr struct {
+		Width, Height int
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &hdr); err != nil {
+		t.Fatalf("invalid header: %v", err)
+	}
+	if hdr.Width != 132 || hdr.Height != 43 {
+		t.Errorf("expected the negotiated window size in the header, got %dx%d", hdr.Width, hdr.Height)
+	}
+	if !strings.Contains(string(data), `"r","100x30"`) {
+		t.Errorf("missing resize event: %s", data)
+	}
+}
+
+func TestReplayBypassesPager(t *testing.T) {
+	dir := t.TempDir()
+	rec := `{"version":2,"width":80,"height":24,"timestamp":0}` + "\n"
+	var want strings.Builder
+	for i := 0; i < 20; i++ {
+		line := fmt.Sprintf("line %d\r\n", i)
+		rec += fmt.Sprintf("[0, \"o\", %q]\n", line)
+		want.WriteString(fmt.Sprintf("line %d\n", i))
+	}
+	if err := os.WriteFile(filepath.Join(dir, "test.cast"), []byte(rec), 0600); err != nil {
+		t.Fatal(err)
+	}
+	h := telgotest.New(t, "> ", telgo.CmdList{"replay": telgo.ReplayCmd(dir)}, nil)
+	h.Server.SetPaging(true)
+	s := h.Connect()
+	s.SetWindowSize(80, 5)
+	s.ExpectPrompt()
+	if out := s.Run("replay test.cast"); out != want.String() {
+		t.Errorf("unexpected replay output: %q", out)
+	}
+}
@@ -0,0 +1,183 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package sshd
+
+import (
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/spreadspace/telgo"
+	"github.com/spreadspace/telgo/console"
+	"golang.org/x/crypto/ssh"
+)
+
+// payloads of the channel requests, see RFC 4254
+type ptyRequest struct {
+	Term    string
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+	Modes   string
+}
+
+type windowChange struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+type execRequest struct {
+	Command string
+}
+
+type signalRequest struct {
+	Signal string
+}
+
+type exitStatus struct {
+	Status uint32
+}
+
+// session is a session channel of an SSH connection
+type session struct {
+	srv    *telgo.Server
+	log    *slog.Logger
+	conn   *ssh.ServerConn
+	ch     ssh.Channel
+	params []interface{}
+
+	mu      sync.Mutex
+	pty     *ptyRequest
+	stream  *telgo.StreamConn
+	started bool
+}
+
+func (s *session) serve(requests <-chan *ssh.Request) {
+	for req := range requests {
+		ok := false
+		switch req.Type {
+		case "pty-req":
+			var p ptyRequest
+			if ssh.Unmarshal(req.Payload, &p) == nil {
+				s.mu.Lock()
+				s.pty = &p
+				s.mu.Unlock()
+				ok = true
+			}
+		case "window-change":
+			var w windowChange
+			if ssh.Unmarshal(req.Payload, &w) == nil {
+				s.resize(int(w.Columns), int(w.Rows))
+				ok = true
+			}
+		case "shell":
+			ok = s.start("")
+		case "exec":
+			var e execRequest
+			if ssh.Unmarshal(req.Payload, &e) == nil {
+				ok = s.start(e.Command)
+			}
+		case "signal":
+			var sig signalRequest
+			if ssh.Unmarshal(req.Payload, &sig) == nil && sig.Signal == "INT" {
+				s.mu.Lock()
+				if s.stream != nil {
+					s.stream.Interrupt()
+				}
+				s.mu.Unlock()
+				ok = true
+			}
+		}
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+	}
+	// the channel has been closed, cancel whatever is still running
+	s.mu.Lock()
+	if s.stream != nil {
+		s.stream.Close()
+	}
+	s.mu.Unlock()
+}
+
+func (s *session) resize(width, height int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pty != nil {
+		s.pty.Columns, s.pty.Rows = uint32(width), uint32(height)
+	}
+	if s.stream != nil {
+		s.stream.SetWindowSize(width, height)
+	}
+}
+
+// start runs an interactive session if command is empty or the command
+// otherwise. Only one of them may be started per channel.
+func (s *session) start(command string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return false
+	}
+	s.started = true
+	pty := s.pty
+	var editor func(io.Reader, io.Writer) telgo.LineEditor
+	if pty != nil {
+		editor = console.Editor
+	}
+	s.stream = telgo.NewStreamConn(s.ch, s.conn.RemoteAddr(), editor)
+	if pty != nil {
+		s.stream.SetTerminalType(pty.Term)
+		s.stream.SetWindowSize(int(pty.Columns), int(pty.Rows))
+	}
+	setup := func(c *telgo.Client) {
+		c.Identity = s.conn.User()
+		if pty != nil { // commands might run before the telnet options have been negotiated
+			c.SetTerminalType(pty.Term)
+			c.SetWindowSize(int(pty.Columns), int(pty.Rows))
+		}
+	}
+	params := append([]interface{}{setup}, s.params...)
+	go func() {
+		status := telgo.StatusOK
+		if command == "" {
+			s.srv.ServeConn(s.stream, params...)
+		} else {
+			s.log.Info("exec request", "command", command)
+			status = s.srv.ExecConn(s.stream, command, params...)
+		}
+		s.ch.SendRequest("exit-status", false, ssh.Marshal(&exitStatus{uint32(status)}))
+		s.ch.Close()
+	}()
+	return true
+}
@@ -0,0 +1,248 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+// Package sshd serves the commands of a telgo server via SSH. Users are
+// authenticated using passwords and/or public keys and the name they logged
+// in with becomes the identity of the session, see telgo.Client.Identity.
+// Interactive sessions with a pseudo terminal get local line editing, the
+// terminal type and window size requested by the SSH client are passed on to
+// the session. Exec requests run a single command and report its exit status:
+//
+//	s, _ := telgo.NewServerFromListener(nil, "> ", cmds, nil)
+//	sd := sshd.New(s)
+//	sd.AddHostKeyFile("/etc/myapp/ssh_host_ed25519_key")
+//	sd.SetAuthorizedKeysFile("/etc/myapp/authorized_keys/%u")
+//	sd.ListenAndServe(":2222")
+package sshd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/spreadspace/telgo"
+	"golang.org/x/crypto/ssh"
+)
+
+// Server serves a telgo server via SSH. Use New to create it.
+type Server struct {
+	srv            *telgo.Server
+	log            *slog.Logger
+	hostKeys       []ssh.Signer
+	password       func(user, password string) bool
+	publicKey      func(user string, key ssh.PublicKey) bool
+	authorizedKeys string
+}
+
+// New creates an SSH front end for the telgo server srv. At least one host
+// key and one authentication method must be configured before serving.
+func New(srv *telgo.Server) *Server {
+	return &Server{srv: srv, log: srv.Logger().With("transport", "ssh")}
+}
+
+// AddHostKey adds a host key used to identify the server.
+func (s *Server) AddHostKey(key ssh.Signer) {
+	s.hostKeys = append(s.hostKeys, key)
+}
+
+// AddHostKeyFile reads a private key in PEM format from path and adds it
+// as host key.
+func (s *Server) AddHostKeyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	key, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	s.AddHostKey(key)
+	return nil
+}
+
+// SetPasswordAuth enables password authentication. check is called for
+// every login attempt and returns true if the password is valid for user.
+func (s *Server) SetPasswordAuth(check func(user, password string) bool) {
+	s.password = check
+}
+
+// SetPublicKeyAuth enables public key authentication. check is called for
+// every key offered by a client and returns true if user may log in using
+// key.
+func (s *Server) SetPublicKeyAuth(check func(user string, key ssh.PublicKey) bool) {
+	s.publicKey = check
+}
+
+// SetAuthorizedKeysFile enables public key authentication using a file per
+// user in the format of OpenSSH's authorized_keys. path must contain "%u"
+// which is replaced by the name the user logs in with, for example
+// "/etc/myapp/authorized_keys/%u". The user may log in with all keys listed
+// in the file. Keys with options like from= or command= are never accepted
+// because options aren't supported. The file is read on every login attempt
+// so changes take effect immediately. Keys accepted by the function passed
+// to SetPublicKeyAuth are accepted as well.
+func (s *Server) SetAuthorizedKeysFile(path string) error {
+	if !strings.Contains(path, "%u") {
+		return errors.New("sshd: path of the authorized keys file must contain %u")
+	}
+	s.authorizedKeys = path
+	return nil
+}
+
+func (s *Server) isAuthorizedKey(user string, key ssh.PublicKey) bool {
+	if user == "" || user == "." || user == ".." || strings.ContainsAny(user, "/\x00") {
+		return false // not usable as part of a path
+	}
+	path := strings.Replace(s.authorizedKeys, "%u", user, -1)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.log.Error("can't read authorized keys", "error", err)
+		}
+		return false
+	}
+	marshaled := key.Marshal()
+	for len(data) > 0 {
+		ak, _, options, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			break // no more keys
+		}
+		if bytes.Equal(ak.Marshal(), marshaled) {
+			if len(options) > 0 {
+				s.log.Warn("rejecting authorized key with options", "file", path, "options", strings.Join(options, ","))
+				return false
+			}
+			return true
+		}
+		data = rest
+	}
+	return false
+}
+
+func (s *Server) config() (*ssh.ServerConfig, error) {
+	if len(s.hostKeys) == 0 {
+		return nil, errors.New("sshd: no host key")
+	}
+	if s.password == nil && s.publicKey == nil && s.authorizedKeys == "" {
+		return nil, errors.New("sshd: no authentication method")
+	}
+	config := &ssh.ServerConfig{}
+	for _, key := range s.hostKeys {
+		config.AddHostKey(key)
+	}
+	if s.password != nil {
+		config.PasswordCallback = func(md ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if s.password(md.User(), string(password)) {
+				return &ssh.Permissions{}, nil
+			}
+			return nil, fmt.Errorf("password rejected for %q", md.User())
+		}
+	}
+	if s.publicKey != nil || s.authorizedKeys != "" {
+		config.PublicKeyCallback = func(md ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if (s.publicKey != nil && s.publicKey(md.User(), key)) || (s.authorizedKeys != "" && s.isAuthorizedKey(md.User(), key)) {
+				return &ssh.Permissions{Extensions: map[string]string{"pubkey-fp": ssh.FingerprintSHA256(key)}}, nil
+			}
+			return nil, fmt.Errorf("unknown public key for %q", md.User())
+		}
+	}
+	return config, nil
+}
+
+// ListenAndServe listens on the TCP address addr and serves SSH
+// connections. The optional parameters are the same as for
+// telgo.Server.Run.
+func (s *Server) ListenAndServe(addr string, params ...interface{}) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln, params...)
+}
+
+// Serve accepts SSH connections on ln and serves every connection in a go
+// routine of its own. The optional parameters are the same as for
+// telgo.Server.Run.
+func (s *Server) Serve(ln net.Listener, params ...interface{}) error {
+	defer ln.Close()
+	if _, err := s.config(); err != nil {
+		return err
+	}
+	s.log.Info("listening", "addr", ln.Addr().String())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.log.Error("Accept() error", "error", err)
+			return err
+		}
+		go s.ServeConn(conn, params...)
+	}
+}
+
+// ServeConn runs the SSH protocol on an already established connection
+// and returns when the connection is closed.
+func (s *Server) ServeConn(nconn net.Conn, params ...interface{}) {
+	config, err := s.config()
+	if err != nil {
+		s.log.Error("can't serve connection", "error", err)
+		nconn.Close()
+		return
+	}
+	conn, chans, reqs, err := ssh.NewServerConn(nconn, config)
+	if err != nil {
+		s.log.Warn("handshake failed", "remote", nconn.RemoteAddr().String(), "error", err)
+		nconn.Close()
+		return
+	}
+	defer conn.Close()
+	log := s.log.With("remote", conn.RemoteAddr().String(), "user", conn.User())
+	if fp, ok := conn.Permissions.Extensions["pubkey-fp"]; ok {
+		log = log.With("key", fp)
+	}
+	log.Info("user logged in")
+
+	go ssh.DiscardRequests(reqs)
+	for nch := range chans {
+		if nch.ChannelType() != "session" {
+			nch.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, requests, err := nch.Accept()
+		if err != nil {
+			log.Warn("can't accept channel", "error", err)
+			continue
+		}
+		sess := &session{srv: s.srv, log: log, conn: conn, ch: ch, params: params}
+		go sess.serve(requests)
+	}
+}
@@ -0,0 +1,93 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package sshd
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spreadspace/telgo"
+	"golang.org/x/crypto/ssh"
+)
+
+func newPublicKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestAuthorizedKeys(t *testing.T) {
+	srv, err := telgo.NewServerFromListener(nil, "> ", telgo.CmdList{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(srv)
+	dir := t.TempDir()
+	if err := s.SetAuthorizedKeysFile(filepath.Join(dir, "keys")); err == nil {
+		t.Error("path without %u has been accepted")
+	}
+	if err := s.SetAuthorizedKeysFile(filepath.Join(dir, "%u")); err != nil {
+		t.Fatal(err)
+	}
+
+	alice, bob, restricted := newPublicKey(t), newPublicKey(t), newPublicKey(t)
+	line := func(key ssh.PublicKey) string {
+		return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	}
+	keys := line(alice) + " bob@laptop\n" + `from="192.0.2.1" ` + line(restricted) + " alice\n"
+	if err := os.WriteFile(filepath.Join(dir, "alice"), []byte(keys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		user string
+		key  ssh.PublicKey
+		want bool
+	}{
+		{"alice", alice, true},
+		{"bob", alice, false}, // the comment doesn't matter
+		{"alice", bob, false},
+		{"alice", restricted, false}, // options aren't supported
+		{"../" + filepath.Base(dir) + "/alice", alice, false},
+		{"", alice, false},
+	} {
+		if got := s.isAuthorizedKey(tt.user, tt.key); got != tt.want {
+			t.Errorf("user %q with key %s: expected %v, got %v", tt.user, ssh.FingerprintSHA256(tt.key), tt.want, got)
+		}
+	}
+}
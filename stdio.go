@@ -0,0 +1,173 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const listenFdsStart = 3 // SD_LISTEN_FDS_START
+
+// SystemdListeners returns the listening sockets passed to the process by
+// systemd socket activation, in the order of the ListenStream= settings of
+// the socket unit. It returns no listeners if the process hasn't been
+// socket activated. The environment variables used by systemd are removed
+// so child processes won't pick up the sockets as well.
+func SystemdListeners() ([]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %v", err)
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	lns := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		fd := listenFdsStart + i
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(f)
+		f.Close() // FileListener works on a copy of the file descriptor
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("socket %s: %v", name, err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// NewSystemdServer creates a telnet server which accepts connections on the
+// socket passed to the process by systemd socket activation. If there is
+// more than one socket only the first one is used and the others are closed,
+// use SystemdListeners together with NewServerFromListener to serve all of
+// them. The remaining parameters are the same as for NewServer.
+func NewSystemdServer(prompt string, commands CmdList, userdata interface{}) (s *Server, err error) {
+	lns, err := SystemdListeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(lns) == 0 {
+		return nil, errors.New("telgo: no sockets passed by systemd")
+	}
+	for _, ln := range lns[1:] {
+		ln.Close()
+	}
+	return NewServerFromListener(lns[0], prompt, commands, userdata)
+}
+
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string { return "stdio" }
+func (stdioAddr) String() string  { return "stdio" }
+
+// stdioConn is a net.Conn which reads from stdin and writes to stdout
+type stdioConn struct {
+	in  *os.File
+	out *os.File
+}
+
+func (c *stdioConn) Read(b []byte) (int, error)  { return c.in.Read(b) }
+func (c *stdioConn) Write(b []byte) (int, error) { return c.out.Write(b) }
+func (c *stdioConn) LocalAddr() net.Addr         { return stdioAddr{} }
+func (c *stdioConn) RemoteAddr() net.Addr        { return stdioAddr{} }
+
+// Close does nothing, the standard streams belong to the process.
+func (c *stdioConn) Close() error { return nil }
+
+func (c *stdioConn) SetDeadline(t time.Time) error {
+	if err := c.in.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.out.SetWriteDeadline(t)
+}
+
+func (c *stdioConn) SetReadDeadline(t time.Time) error  { return c.in.SetReadDeadline(t) }
+func (c *stdioConn) SetWriteDeadline(t time.Time) error { return c.out.SetWriteDeadline(t) }
+
+// SetPlainMode sets whether the session runs on a plain stream instead of a
+// connection to a telnet client. In plain mode there is no telnet option
+// negotiation and every line is run as a command, even if it arrives while
+// another command is still running. Commands don't get any input, see
+// ReadLine. Once the input ends the remaining commands are run before the
+// session gets closed, which makes it possible to pipe commands into the
+// process. It must be called before the session starts, for example by a
+// setup function passed to ServeConn.
+func (c *Client) SetPlainMode(enabled bool) {
+	c.plain = enabled
+}
+
+// PlainMode returns true if the session runs in plain mode, see
+// SetPlainMode.
+func (c *Client) PlainMode() bool {
+	return c.plain
+}
+
+// RunStdio runs a single client session using stdin and stdout of the
+// process, like services started by inetd do. If stdin is a socket, which
+// is the case for inetd as well as for systemd services with Accept=yes,
+// the socket is used directly and a telnet client is expected at the other
+// end. Otherwise stdin and stdout are treated as plain streams, see
+// SetPlainMode. Use the console package for interactive sessions on a local
+// terminal. The standard streams are not closed. RunStdio returns when
+// the session is over. The optional parameters are the same as for Run. The
+// server doesn't need a listener for this, see NewServerFromListener.
+func (s *Server) RunStdio(params ...interface{}) {
+	// only try sockets, FileConn makes other files non-blocking even if it fails
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if sc, err := net.FileConn(os.Stdin); err == nil {
+			s.ServeConn(sc, params...)
+			return
+		}
+	}
+	plain := func(c *Client) {
+		c.SetPlainMode(true)
+	}
+	s.ServeConn(&stdioConn{in: os.Stdin, out: os.Stdout}, append([]interface{}{plain}, params...)...)
+}
@@ -0,0 +1,88 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spreadspace/telgo"
+)
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// pipeConn is a plain stream reading the lines piped into the process
+type pipeConn struct {
+	io.Reader
+	out bytes.Buffer
+}
+
+func (c *pipeConn) Write(b []byte) (int, error)      { return c.out.Write(b) }
+func (c *pipeConn) Close() error                     { return nil }
+func (c *pipeConn) LocalAddr() net.Addr              { return pipeAddr{} }
+func (c *pipeConn) RemoteAddr() net.Addr             { return pipeAddr{} }
+func (c *pipeConn) SetDeadline(time.Time) error      { return nil }
+func (c *pipeConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *pipeConn) SetWriteDeadline(time.Time) error { return nil }
+
+func TestPlainMode(t *testing.T) {
+	cmds := telgo.CmdList{
+		"slow": func(c *telgo.Client, args []string) bool {
+			time.Sleep(50 * time.Millisecond) // the following lines arrive meanwhile
+			if line, ok := c.ReadLine(); ok {
+				c.Sayln("got input %q", line)
+			}
+			c.Sayln("slow done")
+			return false
+		},
+		"echo": func(c *telgo.Client, args []string) bool {
+			c.Sayln("%s", strings.Join(args[1:], " "))
+			return false
+		},
+	}
+	srv, err := telgo.NewServerFromListener(nil, "> ", cmds, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &pipeConn{Reader: strings.NewReader("slow\necho one\n\necho two\n")}
+	srv.ServeConn(conn, func(c *telgo.Client) { c.SetPlainMode(true) })
+
+	want := "> slow done\r\n> one\r\n> > two\r\n"
+	if out := conn.out.String(); out != want {
+		t.Errorf("expected %q, got %q", want, out)
+	}
+}
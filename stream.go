@@ -0,0 +1,339 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// LineEditor edits the lines entered by the user locally, see NewStreamConn.
+// ReadLine returns the next line and an error once the input has ended, Write
+// is called with the output of the server. *term.Terminal of the package
+// golang.org/x/term implements this interface, see the console package.
+type LineEditor interface {
+	ReadLine() (line string, err error)
+	Write(data []byte) (n int, err error)
+	SetSize(width, height int) error
+}
+
+// StreamConn connects a client session to a plain byte stream instead of a
+// telnet client, see NewStreamConn. It acts as telnet client itself: telnet
+// commands are removed from the output and the window size and terminal
+// type are reported to the server using the NAWS and TERMINAL-TYPE options.
+type StreamConn struct {
+	rw        io.ReadWriter
+	remote    net.Addr
+	t         LineEditor
+	in        chan []byte // data for the server
+	rest      []byte
+	keys      chan []byte // data for the line editor
+	keyRest   []byte
+	eof       chan bool // closed once rw has ended
+	done      chan bool
+	closeOnce sync.Once
+
+	mu     sync.Mutex
+	naws   bool
+	width  int
+	height int
+	ttype  string
+}
+
+// NewStreamConn creates a connection for a client session which reads the
+// input of the user from rw and writes the output to rw. remote will be
+// reported as remote address of the connection.
+// If editor is not nil rw is expected to be a terminal which sends every key
+// stroke on its own, like a terminal in raw mode or an SSH session with a
+// pseudo terminal. The lines are then edited locally using the LineEditor
+// created by editor, which reads the keys typed by the user from keys and
+// writes to out, and only complete lines are sent to the server. Ctrl-C is
+// sent as interrupt process command and clears the line being edited.
+// Otherwise the input is passed on as it is and the end of rw is reported
+// to the server as the end of the input.
+// Closing the StreamConn doesn't close rw.
+func NewStreamConn(rw io.ReadWriter, remote net.Addr, editor func(keys io.Reader, out io.Writer) LineEditor) *StreamConn {
+	c := &StreamConn{rw: rw, remote: remote, in: make(chan []byte, 16), eof: make(chan bool), done: make(chan bool)}
+	if editor != nil {
+		c.keys = make(chan []byte)
+		c.t = editor(streamKeyReader{c}, rw)
+		go c.readKeys()
+		go c.readLines()
+	} else {
+		go c.readPlain()
+	}
+	return c
+}
+
+// send queues data which will be read by the server
+func (c *StreamConn) send(data []byte) {
+	select {
+	case c.in <- data:
+	case <-c.done:
+	}
+}
+
+func (c *StreamConn) readPlain() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := c.rw.Read(buf)
+		if n > 0 {
+			c.send(append([]byte(nil), buf[:n]...))
+		}
+		if err != nil {
+			close(c.eof)
+			return
+		}
+	}
+}
+
+// readKeys reads the keys typed by the user. Ctrl-C is sent to the server
+// right away and clears the line being edited.
+func (c *StreamConn) readKeys() {
+	buf := make([]byte, 256)
+	for {
+		n, err := c.rw.Read(buf)
+		if err != nil {
+			close(c.keys)
+			return
+		}
+		keys := append([]byte(nil), buf[:n]...)
+		for i, k := range keys {
+			if k == 3 { // Ctrl-C
+				c.Interrupt()
+				keys[i] = 21 // Ctrl-U
+			}
+		}
+		select {
+		case c.keys <- keys:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+type streamKeyReader struct {
+	c *StreamConn
+}
+
+func (r streamKeyReader) Read(p []byte) (int, error) {
+	c := r.c
+	if len(c.keyRest) == 0 {
+		select {
+		case keys, ok := <-c.keys:
+			if !ok {
+				return 0, io.EOF
+			}
+			c.keyRest = keys
+		case <-c.done:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, c.keyRest)
+	c.keyRest = c.keyRest[n:]
+	return n, nil
+}
+
+// readLines sends the lines entered by the user to the server. The session
+// ends together with the input of the line editor.
+func (c *StreamConn) readLines() {
+	for {
+		line, err := c.t.ReadLine()
+		if err != nil {
+			c.send([]byte{bEOT})
+			return
+		}
+		c.send([]byte(line + "\r\n"))
+	}
+}
+
+// Interrupt sends the interrupt process command to the server which cancels
+// the running command.
+func (c *StreamConn) Interrupt() {
+	c.send([]byte{bIAC, bIP})
+}
+
+// SetTerminalType sets the terminal type reported to the server. It must be
+// called before the session starts.
+func (c *StreamConn) SetTerminalType(ttype string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttype = ttype
+}
+
+// SetWindowSize sets the size of the terminal and reports it to the server.
+func (c *StreamConn) SetWindowSize(width, height int) {
+	if width <= 0 || height <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if width == c.width && height == c.height {
+		return
+	}
+	c.width, c.height = width, height
+	if c.t != nil {
+		c.t.SetSize(width, height)
+	}
+	if !c.naws {
+		c.naws = true
+		c.send([]byte{bIAC, bWILL, optNAWS})
+	}
+	c.sendSize()
+}
+
+// sendSize must be called with c.mu held
+func (c *StreamConn) sendSize() {
+	size := []byte{byte(c.width >> 8), byte(c.width), byte(c.height >> 8), byte(c.height)}
+	sb := append([]byte{bIAC, bSB, optNAWS}, bytes.Replace(size, []byte{bIAC}, []byte{bIAC, bIAC}, -1)...)
+	c.send(append(sb, bIAC, bSE))
+}
+
+// handleIac answers the telnet commands sent by the server.
+func (c *StreamConn) handleIac(cmd []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch cmd[1] {
+	case bDO:
+		switch {
+		case cmd[2] == optNAWS && c.width > 0:
+			if !c.naws {
+				c.naws = true
+				c.send([]byte{bIAC, bWILL, optNAWS})
+				c.sendSize()
+			}
+		case cmd[2] == optTTYPE && c.ttype != "":
+			c.send([]byte{bIAC, bWILL, optTTYPE})
+		default:
+			c.send([]byte{bIAC, bWONT, cmd[2]})
+		}
+	case bDONT:
+		if cmd[2] == optNAWS {
+			c.naws = false
+		}
+	case bWILL:
+		c.send([]byte{bIAC, bDONT, cmd[2]})
+	case bSB:
+		if len(cmd) >= 4 && cmd[2] == optTTYPE && cmd[3] == ttypeSEND {
+			is := append([]byte{bIAC, bSB, optTTYPE, ttypeIS}, c.ttype...)
+			c.send(append(is, bIAC, bSE))
+		}
+	}
+}
+
+// Write is called with the output of the server which is passed on after
+// removing all telnet commands.
+func (c *StreamConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	var out []byte
+	for i := 0; i < len(b); {
+		if b[i] != bIAC {
+			out = append(out, b[i])
+			i++
+			continue
+		}
+		if i+1 < len(b) && b[i+1] == bIAC {
+			out = append(out, bIAC)
+			i += 2
+			continue
+		}
+		l := iacLength(b[i:])
+		if l <= 0 {
+			break
+		}
+		c.handleIac(b[i : i+l])
+		i += l
+	}
+	if len(out) == 0 {
+		return len(b), nil
+	}
+	out = bytes.Replace(out, []byte("\r\n"), []byte("\n"), -1)
+	var err error
+	if c.t != nil {
+		_, err = c.t.Write(out) // the terminal adds the CR on its own
+	} else {
+		_, err = c.rw.Write(out)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read returns the input for the server.
+func (c *StreamConn) Read(b []byte) (int, error) {
+	if len(c.rest) == 0 {
+		select {
+		case data := <-c.in:
+			c.rest = data
+		case <-c.eof:
+			select {
+			case data := <-c.in: // pass on what has been queued before the end
+				c.rest = data
+			default:
+				return 0, io.EOF
+			}
+		case <-c.done:
+			return 0, io.EOF
+		}
+	}
+	n := copy(b, c.rest)
+	c.rest = c.rest[n:]
+	return n, nil
+}
+
+// Close closes the connection, the underlying stream is left open. This
+// tells the server that the client has gone away.
+func (c *StreamConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return nil
+}
+
+// LocalAddr implements the net.Conn interface.
+func (c *StreamConn) LocalAddr() net.Addr { return c.remote }
+
+// RemoteAddr returns the address passed to NewStreamConn.
+func (c *StreamConn) RemoteAddr() net.Addr { return c.remote }
+
+// SetDeadline is not supported and does nothing.
+func (c *StreamConn) SetDeadline(t time.Time) error { return nil }
+
+// SetReadDeadline is not supported and does nothing.
+func (c *StreamConn) SetReadDeadline(t time.Time) error { return nil }
+
+// SetWriteDeadline is not supported and does nothing.
+func (c *StreamConn) SetWriteDeadline(t time.Time) error { return nil }
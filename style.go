@@ -0,0 +1,196 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	sgrCodes = map[string]string{
+		"bold":       "1",
+		"dim":        "2",
+		"italic":     "3",
+		"underline":  "4",
+		"blink":      "5",
+		"reverse":    "7",
+		"black":      "30",
+		"red":        "31",
+		"green":      "32",
+		"yellow":     "33",
+		"blue":       "34",
+		"magenta":    "35",
+		"cyan":       "36",
+		"white":      "37",
+		"bg-black":   "40",
+		"bg-red":     "41",
+		"bg-green":   "42",
+		"bg-yellow":  "43",
+		"bg-blue":    "44",
+		"bg-magenta": "45",
+		"bg-cyan":    "46",
+		"bg-white":   "47",
+	}
+)
+
+const (
+	sgrReset = "\x1b[0m"
+)
+
+// sgr returns the ANSI SGR sequence for a comma separated list of style
+// names. ok is false if one of the names is unknown.
+func sgr(styles string) (seq string, ok bool) {
+	if styles == "/" {
+		return sgrReset, true
+	}
+	var codes []string
+	for _, name := range strings.Split(styles, ",") {
+		code, found := sgrCodes[strings.TrimSpace(name)]
+		if !found {
+			return "", false
+		}
+		codes = append(codes, code)
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m", true
+}
+
+// renderMarkup replaces style markup inside s by ANSI SGR sequences or
+// removes it if color is false.
+func renderMarkup(s string, color bool) string {
+	var b strings.Builder
+	open := false
+	for {
+		i := strings.IndexByte(s, '{')
+		if i < 0 || i+1 >= len(s) {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		if s[i+1] == '{' { // escaped brace
+			b.WriteByte('{')
+			s = s[i+2:]
+			continue
+		}
+		j := strings.IndexByte(s[i:], '}')
+		seq, ok := "", false
+		if j > 0 {
+			seq, ok = sgr(s[i+1 : i+j])
+		}
+		if !ok { // this is not markup
+			b.WriteByte('{')
+			s = s[i+1:]
+			continue
+		}
+		if color {
+			b.WriteString(seq)
+		}
+		open = seq != sgrReset
+		s = s[i+j+1:]
+	}
+	if color && open {
+		b.WriteString(sgrReset) // don't let the style leak into the prompt
+	}
+	return b.String()
+}
+
+// ColorEnabled returns true if styled output will be sent as ANSI escape
+// sequences to this client. This is the case if the terminal supports colors
+// and colors haven't been turned off using SetColor. Colors are never used in
+// machine mode.
+func (c *Client) ColorEnabled() bool {
+	c.termMu.Lock()
+	off := c.noColor || c.machine
+	c.termMu.Unlock()
+	return !off && c.Has(CapColor)
+}
+
+// SetColor turns styled output on or off for this session. Colors are only
+// used if the terminal supports them, regardless of this setting.
+func (c *Client) SetColor(enabled bool) {
+	c.termMu.Lock()
+	defer c.termMu.Unlock()
+	c.noColor = !enabled
+}
+
+// Sayf works like Say but supports style markup inside format. Style names,
+// or comma separated lists of them, enclosed in braces start a style, {/}
+// resets all styles:
+//
+//	c.Sayf("{bold,red}error:{/} %s", err)
+//
+// Supported styles are bold, dim, italic, underline, blink, reverse, the
+// colors black, red, green, yellow, blue, magenta, cyan, white and the
+// same colors with the prefix bg- for the background. Use {{ to get a
+// literal brace. Markup is only interpreted inside format, never inside the
+// arguments. If the session doesn't support colors the markup is removed.
+func (c *Client) Sayf(format string, a ...interface{}) bool {
+	return c.WriteString(fmt.Sprintf(renderMarkup(format, c.ColorEnabled()), a...))
+}
+
+// Sayfln is the same as Sayf but also adds a new-line at the end of the string.
+func (c *Client) Sayfln(format string, a ...interface{}) bool {
+	return c.WriteString(fmt.Sprintf(renderMarkup(format, c.ColorEnabled()), a...) + "\r\n")
+}
+
+// Style returns text wrapped into the ANSI sequences for the comma separated
+// list of styles, see Sayf. If the session doesn't support colors or a style
+// is unknown text is returned unchanged.
+func (c *Client) Style(styles, text string) string {
+	if !c.ColorEnabled() {
+		return text
+	}
+	seq, ok := sgr(styles)
+	if !ok {
+		return text
+	}
+	return seq + text + sgrReset
+}
+
+// ColorCmd is a telgo command which lets the user turn colors on or off for
+// the current session. Without arguments it shows the current setting.
+func ColorCmd(c *Client, args []string) bool {
+	switch {
+	case len(args) == 1:
+	case len(args) == 2 && (args[1] == "on" || args[1] == "off"):
+		c.SetColor(args[1] == "on")
+	default:
+		c.Errorf("usage: %s [on|off]", args[0])
+		return false
+	}
+	if c.ColorEnabled() {
+		c.Sayfln("colors are {green}on{/}")
+	} else if !c.Has(CapColor) {
+		c.Sayln("colors are off (not supported by terminal '%s')", c.TerminalType())
+	} else {
+		c.Sayln("colors are off")
+	}
+	return false
+}
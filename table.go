@@ -0,0 +1,502 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// OutputFormat selects how tables and key/value lists are rendered.
+type OutputFormat int
+
+// These are the supported output formats.
+const (
+	FormatText OutputFormat = iota // aligned columns for humans
+	FormatCSV                      // comma separated values
+	FormatJSON                     // JSON arrays or objects
+)
+
+var (
+	outputFormatNames = map[OutputFormat]string{
+		FormatText: "text",
+		FormatCSV:  "csv",
+		FormatJSON: "json",
+	}
+)
+
+// String returns the name of the output format.
+func (f OutputFormat) String() string {
+	if name, found := outputFormatNames[f]; found {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int(f))
+}
+
+// OutputFormat returns the format used to render tables and key/value lists
+// for this session.
+func (c *Client) OutputFormat() OutputFormat {
+	c.termMu.Lock()
+	defer c.termMu.Unlock()
+	return c.format
+}
+
+// SetOutputFormat sets the format used to render tables and key/value lists
+// for this session.
+func (c *Client) SetOutputFormat(f OutputFormat) {
+	c.termMu.Lock()
+	defer c.termMu.Unlock()
+	c.format = f
+}
+
+// FormatCmd is a telgo command which lets the user select the output format
+// of the current session: text, csv or json. Without arguments it shows the
+// current setting.
+func FormatCmd(c *Client, args []string) bool {
+	if len(args) > 2 {
+		c.Errorf("usage: %s [text|csv|json]", args[0])
+		return false
+	}
+	if len(args) == 2 {
+		found := false
+		for f, name := range outputFormatNames {
+			if name == args[1] {
+				c.SetOutputFormat(f)
+				found = true
+			}
+		}
+		if !found {
+			c.Errorf("unknown output format '%s', must be one of text, csv or json", args[1])
+			return false
+		}
+	}
+	c.Sayln("output format is %s", c.OutputFormat())
+	return false
+}
+
+// Alignment of table columns.
+type Alignment int
+
+// These are the supported alignments.
+const (
+	AlignLeft Alignment = iota
+	AlignRight
+	AlignCenter
+)
+
+const (
+	minColumnWidth = 3
+)
+
+// Table collects rows of cells and renders them to a client using the output
+// format of the session. Text output fits the width of the user's terminal by
+// truncating or wrapping the widest columns.
+type Table struct {
+	c       *Client
+	headers []string
+	rows    [][]string
+	align   map[int]Alignment
+	border  bool
+	wrap    bool
+}
+
+// NewTable creates a new table with the given column headers. The headers
+// may be omitted in which case no header line will be rendered.
+func (c *Client) NewTable(headers ...string) *Table {
+	return &Table{c: c, headers: headers, align: make(map[int]Alignment)}
+}
+
+// SetAlign sets the alignment of column col (counting from 0).
+func (t *Table) SetAlign(col int, a Alignment) *Table {
+	t.align[col] = a
+	return t
+}
+
+// SetBorder enables or disables borders around and between the cells.
+func (t *Table) SetBorder(border bool) *Table {
+	t.border = border
+	return t
+}
+
+// SetWrap selects whether cells which are too wide for the terminal are
+// wrapped onto multiple lines or truncated, which is the default.
+func (t *Table) SetWrap(wrap bool) *Table {
+	t.wrap = wrap
+	return t
+}
+
+// AddRow appends a row to the table. The cells are formatted using fmt.Sprint.
+func (t *Table) AddRow(cells ...interface{}) *Table {
+	row := make([]string, len(cells))
+	for i, cell := range cells {
+		row[i] = fmt.Sprint(cell)
+	}
+	t.rows = append(t.rows, row)
+	return t
+}
+
+func (t *Table) numCols() int {
+	n := len(t.headers)
+	for _, row := range t.rows {
+		if len(row) > n {
+			n = len(row)
+		}
+	}
+	return n
+}
+
+// Render sends the table to the client. If it returns false the client
+// connection is about to be closed.
+func (t *Table) Render() bool {
+	switch t.c.OutputFormat() {
+	case FormatCSV:
+		return t.renderCSV()
+	case FormatJSON:
+		return t.renderJSON()
+	}
+	return t.renderText()
+}
+
+func (t *Table) renderCSV() bool {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if len(t.headers) > 0 {
+		w.Write(t.headers)
+	}
+	w.WriteAll(t.rows)
+	_, err := t.c.Write(buf.Bytes())
+	return err == nil
+}
+
+func (t *Table) renderJSON() bool {
+	var data interface{}
+	if len(t.headers) == 0 {
+		data = t.rows
+	} else {
+		var objs []json.RawMessage
+		for _, row := range t.rows {
+			objs = append(objs, orderedObject(t.headers, row))
+		}
+		data = objs
+	}
+	out, err := json.Marshal(data)
+	if err != nil {
+		return t.c.Errorf("can't render table: %v", err)
+	}
+	return t.c.Sayln("%s", out)
+}
+
+// orderedObject creates a JSON object which keeps the order of the keys.
+func orderedObject(keys []string, values []string) json.RawMessage {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v := []byte("null")
+		if i < len(values) {
+			v, _ = json.Marshal(values[i])
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// borderChars contains the characters used to draw borders. The junctions
+// are ordered top, middle, bottom and left, center, right.
+type borderChars struct {
+	vertical   string
+	horizontal string
+	junctions  [3][3]string
+}
+
+var (
+	asciiBorder = borderChars{"|", "-", [3][3]string{
+		{"+", "+", "+"},
+		{"+", "+", "+"},
+		{"+", "+", "+"},
+	}}
+	unicodeBorder = borderChars{"│", "─", [3][3]string{
+		{"┌", "┬", "┐"},
+		{"├", "┼", "┤"},
+		{"└", "┴", "┘"},
+	}}
+)
+
+// rule draws a horizontal line, pos selects the row of junctions to use.
+func (bc borderChars) rule(widths []int, pos int) string {
+	parts := make([]string, len(widths))
+	for i, w := range widths {
+		parts[i] = strings.Repeat(bc.horizontal, w+2)
+	}
+	j := bc.junctions[pos]
+	return j[0] + strings.Join(parts, j[1]) + j[2]
+}
+
+func (t *Table) renderText() bool {
+	n := t.numCols()
+	if n == 0 {
+		return true
+	}
+	widths := make([]int, n)
+	measure := func(row []string) {
+		for i, cell := range row {
+			if l := utf8.RuneCountInString(cell); l > widths[i] {
+				widths[i] = l
+			}
+		}
+	}
+	measure(t.headers)
+	for _, row := range t.rows {
+		measure(row)
+	}
+
+	bc := asciiBorder
+	if t.c.Has(CapUnicode) {
+		bc = unicodeBorder
+	}
+	sep := "  "
+	overhead := 0
+	if t.border {
+		sep = " " + bc.vertical + " "
+		overhead = 4 // the outer borders and their padding
+	}
+	termWidth, _ := t.c.WindowSize()
+	fitWidths(widths, termWidth-(n-1)*utf8.RuneCountInString(sep)-overhead)
+
+	if t.border && !t.c.Sayln("%s", bc.rule(widths, 0)) {
+		return false
+	}
+	if len(t.headers) > 0 {
+		if !t.renderRow(t.headers, widths, sep, bc, true) {
+			return false
+		}
+		if t.border {
+			if !t.c.Sayln("%s", bc.rule(widths, 1)) {
+				return false
+			}
+		} else {
+			var parts []string
+			for _, w := range widths {
+				parts = append(parts, strings.Repeat("-", w))
+			}
+			if !t.c.Sayln("%s", strings.Join(parts, sep)) {
+				return false
+			}
+		}
+	}
+	for _, row := range t.rows {
+		if !t.renderRow(row, widths, sep, bc, false) {
+			return false
+		}
+	}
+	if t.border {
+		return t.c.Sayln("%s", bc.rule(widths, 2))
+	}
+	return true
+}
+
+// fitWidths shrinks the widest columns until the sum of all widths fits into
+// avail or every column has reached the minimum width.
+func fitWidths(widths []int, avail int) {
+	for {
+		total, widest := 0, 0
+		for i, w := range widths {
+			total += w
+			if w > widths[widest] {
+				widest = i
+			}
+		}
+		if total <= avail || widths[widest] <= minColumnWidth {
+			return
+		}
+		widths[widest]--
+	}
+}
+
+func (t *Table) renderRow(row []string, widths []int, sep string, bc borderChars, header bool) bool {
+	cells := make([][]string, len(widths))
+	lines := 1
+	for i := range widths {
+		cell := ""
+		if i < len(row) {
+			cell = row[i]
+		}
+		if t.wrap {
+			cells[i] = wrapText(cell, widths[i])
+		} else {
+			cells[i] = []string{truncateText(cell, widths[i], t.c.Has(CapUnicode))}
+		}
+		if len(cells[i]) > lines {
+			lines = len(cells[i])
+		}
+	}
+	for l := 0; l < lines; l++ {
+		parts := make([]string, len(widths))
+		for i, w := range widths {
+			text := ""
+			if l < len(cells[i]) {
+				text = cells[i][l]
+			}
+			parts[i] = pad(text, w, t.align[i])
+			if header {
+				parts[i] = t.c.Style("bold", parts[i])
+			}
+		}
+		line := strings.Join(parts, sep)
+		if t.border {
+			line = bc.vertical + " " + line + " " + bc.vertical
+		} else {
+			line = strings.TrimRight(line, " ")
+		}
+		if !t.c.Sayln("%s", line) {
+			return false
+		}
+	}
+	return true
+}
+
+func pad(text string, width int, a Alignment) string {
+	missing := width - utf8.RuneCountInString(text)
+	if missing <= 0 {
+		return text
+	}
+	switch a {
+	case AlignRight:
+		return strings.Repeat(" ", missing) + text
+	case AlignCenter:
+		return strings.Repeat(" ", missing/2) + text + strings.Repeat(" ", missing-missing/2)
+	}
+	return text + strings.Repeat(" ", missing)
+}
+
+func truncateText(text string, width int, unicode bool) string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+	if unicode {
+		return string(runes[:width-1]) + "…"
+	}
+	return string(runes[:width-1]) + "~"
+}
+
+// wrapText splits text into lines of at most width characters. Lines are
+// broken at spaces if possible.
+func wrapText(text string, width int) (lines []string) {
+	runes := []rune(text)
+	for len(runes) > width {
+		cut := width
+		for i := width; i > 0; i-- {
+			if runes[i] == ' ' {
+				cut = i
+				break
+			}
+		}
+		lines = append(lines, strings.TrimRight(string(runes[:cut]), " "))
+		runes = runes[cut:]
+		for len(runes) > 0 && runes[0] == ' ' {
+			runes = runes[1:]
+		}
+	}
+	return append(lines, string(runes))
+}
+
+// KeyValue collects a list of key/value pairs and renders them to a client
+// using the output format of the session. Text output aligns all values and
+// wraps them to the width of the user's terminal.
+type KeyValue struct {
+	c      *Client
+	keys   []string
+	values []string
+}
+
+// NewKeyValue creates a new empty key/value list.
+func (c *Client) NewKeyValue() *KeyValue {
+	return &KeyValue{c: c}
+}
+
+// Add appends a pair to the list. The value is formatted using fmt.Sprint.
+func (kv *KeyValue) Add(key string, value interface{}) *KeyValue {
+	kv.keys = append(kv.keys, key)
+	kv.values = append(kv.values, fmt.Sprint(value))
+	return kv
+}
+
+// Render sends the list to the client. If it returns false the client
+// connection is about to be closed.
+func (kv *KeyValue) Render() bool {
+	switch kv.c.OutputFormat() {
+	case FormatCSV:
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		for i := range kv.keys {
+			w.Write([]string{kv.keys[i], kv.values[i]})
+		}
+		w.Flush()
+		_, err := kv.c.Write(buf.Bytes())
+		return err == nil
+	case FormatJSON:
+		return kv.c.Sayln("%s", orderedObject(kv.keys, kv.values))
+	}
+
+	keywidth := 0
+	for _, key := range kv.keys {
+		if l := utf8.RuneCountInString(key); l > keywidth {
+			keywidth = l
+		}
+	}
+	termWidth, _ := kv.c.WindowSize()
+	valwidth := termWidth - keywidth - 2
+	if valwidth < minColumnWidth {
+		valwidth = minColumnWidth
+	}
+	for i, key := range kv.keys {
+		for j, line := range wrapText(kv.values[i], valwidth) {
+			k := ""
+			if j == 0 {
+				k = key + ":"
+			}
+			if !kv.c.Sayln("%s %s", kv.c.Style("bold", pad(k, keywidth+1, AlignLeft)), line) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -32,34 +32,52 @@
 // The telgo telnet server does all the client handling and runs configurable
 // commands as go routines. It also supports handling of basic inline telnet
 // commands used by variaus telnet clients to configure the connection.
-// For now every negotiable telnet option will be discarded but the telnet
-// command IP (interrupt process) is understood and can be used to terminate
-// long running user commands.
-// If the environment contains the variable TELGO_DEBUG logging will be enabled.
-// By default telgo doesn't log anything.
+// The telnet options NAWS (window size) and TERMINAL-TYPE are supported, every
+// other negotiable telnet option will be discarded. The telnet command IP (interrupt process)
+// is understood and can be used to terminate long running user commands.
+// Programmatic clients can switch a session into machine mode, see MachineCmd,
+// where requests and responses are exchanged as JSON objects.
+// Every server logs connects, disconnects, commands, telnet option negotiation
+// and errors using structured logging (log/slog). Use Server.SetLogger to
+// supply a logger. If no logger is set and the environment contains the
+// variable TELGO_DEBUG logging to stderr will be enabled, otherwise telgo
+// doesn't log anything.
 package telgo
 
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net"
 	"os"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
 var (
-	tl = log.New(ioutil.Discard, "[telgo]\t", log.LstdFlags)
+	defaultLogger = newDefaultLogger()
+	lastSessionID uint64
+
+	errClientClosed = errors.New("client connection closed")
+	errCmdCanceled  = errors.New("command canceled")
 )
 
-func init() {
+func newDefaultLogger() *slog.Logger {
 	if _, exists := os.LookupEnv("TELGO_DEBUG"); exists {
-		tl.SetOutput(os.Stderr)
+		h := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+		return slog.New(h).With("component", "telgo")
 	}
+	return slog.New(slog.NewTextHandler(ioutil.Discard, nil))
 }
 
 const (
@@ -82,6 +100,10 @@ const (
 	bSE   = byte(240)
 )
 
+const (
+	stdinBufferLines = 32
+)
+
 type telnetCmd struct {
 	length      int
 	name        string
@@ -129,51 +151,139 @@ type Greeter interface {
 // The Cancel channel will get ready for reading when the user hits Ctrl-C or
 // the connection got terminated. This can be used to abort long running telgo
 // commands.
+// Identity may be set by commands or transports which authenticate the user.
+// It is reported to the Auditor together with every command.
+// The WindowChanged channel will get ready for reading whenever the size of the
+// user's terminal changes, see WindowSize.
 type Client struct {
-	Conn     net.Conn
-	UserData interface{}
-	Cancel   chan bool
-	scanner  *bufio.Scanner
-	writer   *bufio.Writer
-	prompt   string
-	Prompt   string
-	greeter  Greeter
-	commands *CmdList
-	dfltCmd  Cmd
-	iacout   chan []byte
-	stdout   chan []byte
-	quitSend chan bool
-}
-
-func newClient(conn net.Conn, prompt string, greeter Greeter, commands *CmdList, dflt Cmd, userdata interface{}) (c *Client) {
-	tl.Println("new client from:", conn.RemoteAddr())
+	Conn          net.Conn
+	UserData      interface{}
+	Cancel        chan bool
+	Identity      string
+	WindowChanged chan bool
+	srv           *Server
+	cancelled     int32
+	ctxMu         sync.Mutex
+	ctx           context.Context
+	ctxCancel     context.CancelCauseFunc
+	id            uint64
+	log           *slog.Logger
+	rec           *recorder
+	started       time.Time
+	scanner       *bufio.Scanner
+	writer        *bufio.Writer
+	prompt        string
+	Prompt        string
+	greeter       Greeter
+	commands      *CmdList
+	dfltCmd       Cmd
+	iacout        chan []byte
+	stdout        chan []byte
+	stdin         chan string
+	quitSend      chan bool
+	sendDone      chan bool
+	inputClosed   chan bool
+	gotEOT        bool // set by recv before the input channel gets closed
+	plain         bool
+
+	remoteOpts map[byte]*remoteOption
+	optMu      sync.Mutex
+	localOpts  map[byte]*localOption
+	charMode   int32
+	termMu     sync.Mutex
+	width      int
+	height     int
+	termType   string
+	caps       Capability
+	noColor    bool
+	format     OutputFormat
+	paging     bool
+	pagerMu    sync.Mutex
+	pager      *pager
+	widgetMu   sync.Mutex
+	widgets    []widget
+	cmdErrMu   sync.Mutex
+	cmdErr     error
+	peer       *PeerCred
+	machine    bool
+	capture    *bytes.Buffer
+}
+
+func newClient(conn net.Conn, s *Server, greeter Greeter, dflt Cmd) (c *Client) {
 	c = &Client{}
 	c.Conn = conn
+	c.srv = s
+	c.id = atomic.AddUint64(&lastSessionID, 1)
+	c.log = s.logger().With("session", c.id, "remote", conn.RemoteAddr().String())
+	if pc, ok := conn.(*proxyConn); ok && pc.remote != nil {
+		c.log = c.log.With("proxy", pc.Conn.RemoteAddr().String())
+	}
+	c.started = time.Now()
 	c.scanner = bufio.NewScanner(conn)
 	c.writer = bufio.NewWriter(conn)
-	c.prompt = prompt
+	c.prompt = s.prompt
 	c.Prompt = ""
 	c.greeter = greeter
-	c.commands = commands
+	c.commands = &s.commands
 	c.dfltCmd = dflt
-	c.UserData = userdata
+	c.UserData = s.userdata
 	c.stdout = make(chan []byte)
+	c.stdin = make(chan string, stdinBufferLines)
 	c.quitSend = make(chan bool)
+	c.sendDone = make(chan bool)
+	c.inputClosed = make(chan bool)
 	c.Cancel = make(chan bool, 1)
+	c.WindowChanged = make(chan bool, 1)
+	c.remoteOpts = make(map[byte]*remoteOption)
+	c.localOpts = make(map[byte]*localOption)
+	for _, opt := range supportedLocalOptions {
+		c.localOpts[opt] = &localOption{}
+	}
+	c.width, c.height = defaultWidth, defaultHeight
+	c.paging = s.paging
+	c.machine = s.machine
 	// the telnet split function needs some closures to handle inline telnet commands
 	c.iacout = make(chan []byte)
 	lastiiac := 0
 	c.scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		return scanLines(data, atEOF, c.iacout, &lastiiac)
+		if c.inCharMode() {
+			return scanChars(data, atEOF, c.handleIac, &lastiiac, c.inCharMode)
+		}
+		return scanLines(data, atEOF, c.handleIac, &lastiiac, c.inCharMode)
 	})
+	c.initPeerCred()
+	c.log.Info("client connected")
+	if s.recordDir != "" {
+		var err error
+		if c.rec, err = newRecorder(s.recordDir, c); err != nil {
+			c.log.Error("can't start session recording", "error", err)
+		}
+	}
 	return c
 }
 
+// SessionID returns the unique id of this client session. The id is also
+// part of every log message concerning this session.
+func (c *Client) SessionID() uint64 {
+	return c.id
+}
+
 // WriteString writes a 'raw' string to the client. For most purposes the usage of
 // Say and Sayln is recommended. WriteString will take care of escaping IAC bytes
 // inside your string. This function returns false if the client connection has been
-// closed and the client is about to go away.
+// closed and the client is about to go away. While the pager is active it also
+// returns false if the user chose to skip the rest of the output.
 func (c *Client) WriteString(text string) bool {
+	if c.captureOutput(text) {
+		return true
+	}
+	if p := c.activePager(); p != nil {
+		return p.write(text)
+	}
+	return c.writeRaw(text)
+}
+
+func (c *Client) writeRaw(text string) bool {
 	select {
 	case _, ok := <-c.quitSend:
 		if !ok {
@@ -181,8 +291,12 @@ func (c *Client) WriteString(text string) bool {
 		}
 	default:
 	}
-	c.stdout <- bytes.Replace([]byte(text), []byte{bIAC}, []byte{bIAC, bIAC}, -1)
-	return true
+	select {
+	case c.stdout <- bytes.Replace([]byte(text), []byte{bIAC}, []byte{bIAC, bIAC}, -1):
+		return true
+	case <-c.quitSend:
+		return false
+	}
 }
 
 // Say is a simple Printf-like interface which sends responses to the client. If it
@@ -197,6 +311,65 @@ func (c *Client) Sayln(format string, a ...interface{}) bool {
 	return c.WriteString(fmt.Sprintf(format, a...) + "\r\n")
 }
 
+// Write implements the io.Writer interface. This makes it possible to use
+// the client as output for functions like fmt.Fprintf. Every new-line will be
+// converted to the CR-LF sequence needed by telnet clients.
+func (c *Client) Write(p []byte) (n int, err error) {
+	data := bytes.Replace(p, []byte("\r\n"), []byte("\n"), -1)
+	data = bytes.Replace(data, []byte("\n"), []byte("\r\n"), -1)
+	if !c.WriteString(string(data)) {
+		return 0, errClientClosed
+	}
+	return len(p), nil
+}
+
+// ReadLine reads the next line the user enters while the command is running.
+// ok is false if the command got canceled, the connection got closed or the
+// input has ended and all lines have been read.
+// Lines entered by the user while no command is reading them are buffered,
+// the buffer is cleared before every command. In plain mode, see
+// SetPlainMode, commands don't get any input.
+func (c *Client) ReadLine() (line string, ok bool) {
+	select {
+	case line = <-c.stdin:
+		return line, true
+	case <-c.Context().Done():
+		return "", false
+	case <-c.inputClosed:
+		select { // lines which have been received before the input ended
+		case line = <-c.stdin:
+			return line, true
+		default:
+			return "", false
+		}
+	}
+}
+
+type clientReader struct {
+	c   *Client
+	buf []byte
+}
+
+func (r *clientReader) Read(p []byte) (n int, err error) {
+	if len(r.buf) == 0 {
+		line, ok := r.c.ReadLine()
+		if !ok {
+			return 0, io.EOF
+		}
+		r.buf = []byte(line + "\n")
+	}
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Stdin returns a reader which delivers the lines the user enters, using
+// ReadLine, terminated by a new-line. The reader returns io.EOF once the
+// command got canceled or the connection got closed.
+func (c *Client) Stdin() io.Reader {
+	return &clientReader{c: c}
+}
+
 var (
 	escapeRe = regexp.MustCompile("\\\\.")
 )
@@ -233,6 +406,22 @@ func backslashAndQuotes(r rune) bool {
 	return r == rune('\\') || r == rune('"')
 }
 
+// SplitArguments splits a command line into the command name and its
+// arguments the same way the server does for lines entered by the user.
+func SplitArguments(line string) ([]string, error) {
+	return splitCmdArguments(line)
+}
+
+// QuoteArgument quotes arg if necessary so that SplitArguments returns it
+// unchanged as a single argument.
+func QuoteArgument(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, "\"\\") && strings.IndexFunc(arg, unicode.IsSpace) < 0 {
+		return arg
+	}
+	arg = strings.Replace(arg, "\\", "\\\\", -1)
+	return "\"" + strings.Replace(arg, "\"", "\\\"", -1) + "\""
+}
+
 func splitCmdArguments(cmdstr string) (cmds []string, err error) {
 	sepFunc := spacesAndQuotes
 	foundQuote := false
@@ -290,51 +479,145 @@ func (c *Client) handleCmd(cmdstr string, done chan<- bool) {
 
 	cmdslice, err := splitCmdArguments(cmdstr)
 	if err != nil {
+		c.log.Warn("can't parse command", "error", err)
 		c.Sayln("can't parse command: %s", err)
+		c.audit(cmdstr, nil, time.Now(), false, err)
 		return
 	}
 
 	if len(cmdslice) == 0 || cmdslice[0] == "" {
 		return
 	}
+	quit, _ = c.runCmd(cmdstr, cmdslice)
+}
 
+// resetCmd forgets about cancel requests and input meant for the previous
+// command. It is called by exec before a new command line gets handled.
+func (c *Client) resetCmd() {
 	select {
 	case <-c.Cancel: // consume potentially pending cancel request
 	default:
 	}
+	atomic.StoreInt32(&c.cancelled, 0)
+	c.drainStdin()
+}
+
+// runCmd runs the command described by cmdslice. line is the command line as
+// entered by the user and is only used for reporting.
+func (c *Client) runCmd(line string, cmdslice []string) (quit bool, err error) {
+	start := time.Now()
+	var forcePager bool
+	if !c.MachineMode() { // machine clients get their arguments exactly as sent
+		if cmdslice, forcePager = stripMore(line, cmdslice); len(cmdslice) == 0 {
+			return false, nil
+		}
+	}
+
+	select {
+	case <-c.WindowChanged: // only changes while the command is running are of interest
+	default:
+	}
+	c.setCmdErr(nil)
+
+	defer c.newContext()()
+	defer c.startPager(forcePager)()
+	defer c.finishWidgets()
+	quit, err = c.dispatch(cmdslice)
+	if err == nil {
+		err = c.cmdError()
+	}
+	c.log.Info("command executed", "command", cmdslice[0], "duration", time.Since(start), "quit", quit)
+	c.audit(line, cmdslice, start, quit, err)
+	return quit, err
+}
+
+// Errorf reports that the running command has failed. The message is shown to
+// the user, passed to the Auditor and returned to clients in machine mode,
+// see MachineCmd. Like Say it returns false if the client connection is about
+// to be closed.
+func (c *Client) Errorf(format string, a ...interface{}) bool {
+	err := fmt.Errorf(format, a...)
+	c.setCmdErr(err)
+	if c.MachineMode() {
+		return true
+	}
+	return c.Sayfln("{bold,red}error:{/} %s", err)
+}
+
+func (c *Client) setCmdErr(err error) {
+	c.cmdErrMu.Lock()
+	defer c.cmdErrMu.Unlock()
+	c.cmdErr = err
+}
+
+func (c *Client) cmdError() error {
+	c.cmdErrMu.Lock()
+	defer c.cmdErrMu.Unlock()
+	return c.cmdErr
+}
+
+// drainStdin discards lines typed ahead by the user.
+func (c *Client) drainStdin() {
+	for {
+		select {
+		case <-c.stdin:
+		default:
+			return
+		}
+	}
+}
+
+func (c *Client) dispatch(cmdslice []string) (bool, error) {
 	for cmd, cmdfunc := range *c.commands {
 		if cmdslice[0] == cmd {
-			quit = cmdfunc(c, cmdslice)
-			return
+			return cmdfunc(c, cmdslice), nil
 		}
 	}
 	if c.dfltCmd != nil {
-		quit = c.dfltCmd(c, cmdslice)
-		return
+		return c.dfltCmd(c, cmdslice), nil
 	}
 	c.Sayln("unknown command '%s'", cmdslice[0])
+	return false, fmt.Errorf("unknown command '%s'", cmdslice[0])
 }
 
 func (c *Client) runGreeter(done chan<- bool) {
-	done <- c.greeter.Exec(c, []string{"greeter"})
-}
-
-// parse the telnet command and send out out-of-band responses to them
-func handleIac(iac []byte, iacout chan<- []byte) {
-	switch iac[1] {
-	case bWILL, bWONT:
-		iac[1] = bDONT // deny the client to use any proposed options
-	case bDO, bDONT:
-		iac[1] = bWONT // refuse the usage of any requested options
-	case bIP:
-		// pass this through to client.handle which will cancel the process
-	case bIAC:
-		return // just an escaped IAC, this will be dealt with by dropIAC
-	default:
-		tl.Printf("ignoring unimplemented telnet command: %s (%s)", telnetCmds[iac[1]].name, telnetCmds[iac[1]].description)
-		return
+	quit := false
+	defer func() { done <- quit }()
+	defer c.newContext()()
+	defer c.finishWidgets()
+	quit = c.greeter.Exec(c, []string{"greeter"})
+}
+
+// newContext creates the context for the next command, the returned function
+// must be called when the command is done.
+func (c *Client) newContext() func() {
+	c.ctxMu.Lock()
+	defer c.ctxMu.Unlock()
+	c.ctx, c.ctxCancel = context.WithCancelCause(context.Background())
+	if atomic.LoadInt32(&c.cancelled) != 0 { // canceled before the command started
+		c.ctxCancel(errCmdCanceled)
+	}
+	cancel := c.ctxCancel
+	return func() { cancel(nil) }
+}
+
+// canceled returns true if ctx has been canceled by the user or because the
+// connection got terminated rather than because the command has finished.
+func canceled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errCmdCanceled)
+}
+
+// Context returns the context of the running command. It will be canceled
+// when the user hits Ctrl-C, the connection gets terminated or the command
+// has finished. This is an alternative to the Cancel channel which is more
+// convenient to use with functions which accept a context.
+func (c *Client) Context() context.Context {
+	c.ctxMu.Lock()
+	defer c.ctxMu.Unlock()
+	if c.ctx == nil {
+		return context.Background()
 	}
-	iacout <- iac
+	return c.ctx
 }
 
 // remove the carriage return at the end of the line
@@ -355,14 +638,8 @@ func dropIAC(data []byte) []byte {
 		if niiac >= 0 {
 			token = append(token, data[iiac:iiac+niiac]...)
 			iiac += niiac
-			if (len(data) - iiac) < 2 { // check if the data at least contains a command code
-				return token // something is fishy.. found an IAC but this is the last byte of the token...
-			}
-			l := 2 // if we don't know this command - assume it has a length of 2
-			if cmd, found := telnetCmds[data[iiac+1]]; found {
-				l = cmd.length
-			}
-			if (len(data) - iiac) < l { // check if the command is complete
+			l := iacLength(data[iiac:])
+			if l == 0 { // check if the command is complete
 				return token // something is fishy.. found an IAC but the command is too short...
 			}
 			if data[iiac+1] == bIAC { // escaped IAC found
@@ -389,13 +666,24 @@ func compareIdx(a, b int) int {
 	return a - b
 }
 
-func scanLines(data []byte, atEOF bool, iacout chan<- []byte, lastiiac *int) (advance int, token []byte, err error) {
+// This works like bytes.IndexByte but starts searching at index from.
+func indexByteFrom(data []byte, c byte, from int) int {
+	i := bytes.IndexByte(data[from:], c)
+	if i < 0 {
+		return i
+	}
+	return from + i
+}
+
+func scanLines(data []byte, atEOF bool, handleIac func([]byte), lastiiac *int, charMode func() bool) (advance int, token []byte, err error) {
 	if atEOF && len(data) == 0 {
 		return 0, nil, nil
 	}
 
-	inl := bytes.IndexByte(data, '\n')  // index of first newline character
-	ieot := bytes.IndexByte(data, bEOT) // index of first End of Transmission
+	// everything before lastiiac has been dealt with already, newlines or EOTs found
+	// there are part of telnet commands (i.e. subnegotiation parameters)
+	inl := indexByteFrom(data, '\n', *lastiiac)  // index of first newline character
+	ieot := indexByteFrom(data, bEOT, *lastiiac) // index of first End of Transmission
 
 	iiac := *lastiiac
 	for {
@@ -415,19 +703,22 @@ func scanLines(data []byte, atEOF bool, iacout chan<- []byte, lastiiac *int) (ad
 			return ieot + 1, data[ieot : ieot+1], nil // found an EOT (aka Ctrl-D was hit) and no IAC
 		}
 		if iiac >= 0 { // found an IAC
-			if (len(data) - iiac) < 2 {
-				return 0, nil, nil // data does not yet contain the telnet command code -> need more data
-			}
-			l := 2 // if we don't know this command - assume it has a length of 2
-			if cmd, found := telnetCmds[data[iiac+1]]; found {
-				l = cmd.length
-			}
-			if (len(data) - iiac) < l {
+			l := iacLength(data[iiac:])
+			if l == 0 {
 				return 0, nil, nil // data does not yet contain the complete telnet command -> need more data
 			}
-			handleIac(data[iiac:iiac+l], iacout)
+			handleIac(data[iiac : iiac+l])
 			iiac += l
 			*lastiiac = iiac
+			if charMode() { // the telnet command switched the client to character mode
+				return scanChars(data, atEOF, handleIac, lastiiac, charMode)
+			}
+			if inl >= 0 && inl < iiac {
+				inl = indexByteFrom(data, '\n', iiac)
+			}
+			if ieot >= 0 && ieot < iiac {
+				ieot = indexByteFrom(data, bEOT, iiac)
+			}
 		} else {
 			break
 		}
@@ -438,25 +729,89 @@ func scanLines(data []byte, atEOF bool, iacout chan<- []byte, lastiiac *int) (ad
 	return 0, nil, nil // we have found none of the escape codes -> need more data
 }
 
+// scanChars is the split function used in character mode where every
+// character the user typed is a token of its own. Enter is reported as "\r".
+// Characters which were typed in line mode but haven't been completed to a
+// line are dropped.
+func scanChars(data []byte, atEOF bool, handleIac func([]byte), lastiiac *int, charMode func() bool) (advance int, token []byte, err error) {
+	i := *lastiiac // everything before lastiiac has been dealt with already
+	for i < len(data) && data[i] == bIAC {
+		l := iacLength(data[i:])
+		if l == 0 {
+			break // data does not yet contain the complete telnet command
+		}
+		if data[i+1] == bIAC { // escaped IAC
+			*lastiiac = 0
+			return i + l, data[i+1 : i+2], nil
+		}
+		handleIac(data[i : i+l])
+		i += l
+		*lastiiac = i
+		if !charMode() { // the telnet command switched the client back to line mode
+			return scanLines(data, atEOF, handleIac, lastiiac, charMode)
+		}
+	}
+	if i >= len(data) || data[i] == bIAC {
+		if atEOF {
+			*lastiiac = 0
+			return len(data), nil, nil
+		}
+		return 0, nil, nil // need more data
+	}
+
+	l := 1
+	switch {
+	case data[i] == '\r': // telnet clients send CR LF or CR NUL
+		if i+1 >= len(data) && !atEOF {
+			return 0, nil, nil
+		}
+		if i+1 < len(data) && (data[i+1] == '\n' || data[i+1] == 0) {
+			*lastiiac = 0
+			return i + 2, data[i : i+1], nil
+		}
+	case !utf8.FullRune(data[i:]) && !atEOF:
+		return 0, nil, nil
+	default:
+		_, l = utf8.DecodeRune(data[i:])
+	}
+	*lastiiac = 0
+	return i + l, data[i : i+l], nil
+}
+
 func (c *Client) recv(in chan<- string) {
 	defer close(in)
 
+	c.negotiate()
+
 	for c.scanner.Scan() {
 		b := c.scanner.Bytes()
 		if len(b) > 0 && b[0] == bEOT {
-			tl.Printf("client(%s): Ctrl-D received, closing", c.Conn.RemoteAddr())
+			c.gotEOT = true
+			c.rec.input("\x04")
+			c.log.Info("Ctrl-D received, closing")
 			return
 		}
+		// clients which haven't answered NAWS yet won't tell their window size
+		c.rec.begin()
+		if !c.inCharMode() { // there is no echo in character mode
+			c.rec.input(c.srv.redactLine(string(b)) + "\r\n")
+		}
 		in <- string(b)
 	}
 	if err := c.scanner.Err(); err != nil {
-		tl.Printf("client(%s): recv() error: %s", c.Conn.RemoteAddr(), err)
+		c.log.Error("recv() error", "error", err)
 	} else {
-		tl.Printf("client(%s): Connection closed by foreign host", c.Conn.RemoteAddr())
+		c.log.Info("connection closed by foreign host")
 	}
 }
 
 func (c *Client) cancel() {
+	atomic.StoreInt32(&c.cancelled, 1)
+	c.ctxMu.Lock()
+	if c.ctxCancel != nil {
+		c.ctxCancel(errCmdCanceled)
+	}
+	c.ctxMu.Unlock()
 	select {
 	case c.Cancel <- true:
 	default: // process got canceled already
@@ -464,6 +819,7 @@ func (c *Client) cancel() {
 }
 
 func (c *Client) send() {
+	defer close(c.sendDone)
 	for {
 		select {
 		case _, ok := <-c.quitSend:
@@ -472,12 +828,14 @@ func (c *Client) send() {
 			}
 		case iac := <-c.iacout:
 			if iac[1] == bIP {
+				c.rec.input("\x03")
 				c.cancel()
 			} else {
 				c.writer.Write(iac)
 				c.writer.Flush()
 			}
 		case data := <-c.stdout:
+			c.rec.output(data)
 			c.writer.Write(data)
 			c.writer.Flush()
 		}
@@ -485,6 +843,9 @@ func (c *Client) send() {
 }
 
 func (c *Client) writePrompt() {
+	if c.MachineMode() {
+		return
+	}
 	if c.Prompt == "" {
 		c.WriteString(c.prompt)
 	} else {
@@ -492,20 +853,60 @@ func (c *Client) writePrompt() {
 	}
 }
 
+// startCmd starts handling the command line cmd and returns false if there
+// is nothing to run.
+func (c *Client) startCmd(cmd string, done chan<- bool) bool {
+	if c.MachineMode() {
+		if strings.TrimSpace(cmd) == "" {
+			return false
+		}
+		c.resetCmd()
+		go c.handleMachineCmd(cmd, done)
+		return true
+	}
+	if len(cmd) == 0 {
+		c.writePrompt()
+		return false
+	}
+	c.resetCmd()
+	go c.handleCmd(cmd, done)
+	return true
+}
+
 func (c *Client) handle() {
+	defer func() {
+		c.log.Info("client disconnected", "duration", time.Since(c.started))
+	}()
 	defer c.Conn.Close()
+	defer c.rec.close()
 
 	in := make(chan string)
 	go c.recv(in)
 
 	go c.send()
-	defer close(c.quitSend)
+	defer func() {
+		close(c.quitSend)
+		<-c.sendDone // make sure pending output has been written before closing the connection
+	}()
 
 	defer c.cancel() // make sure to cancel possible running job when closing connection
 
+	defer func() {
+		select {
+		case <-c.inputClosed:
+		default:
+			close(c.inputClosed)
+		}
+	}()
+
+	if c.plain { // commands don't get any input, every line is a command
+		close(c.inputClosed)
+	}
+	var queued []string // lines of plain streams waiting for the running command
+
 	done := make(chan bool)
 	busy := false
-	if c.greeter != nil {
+	if c.greeter != nil && !c.MachineMode() {
 		go c.runGreeter(done)
 		busy = true
 	} else {
@@ -515,22 +916,38 @@ func (c *Client) handle() {
 		select {
 		case cmd, ok := <-in:
 			if !ok { // Ctrl-D or recv error (connection closed...)
-				return
+				if !c.plain || !busy {
+					return
+				}
+				// there is no telnet client which might have gone away,
+				// let the running command and the queued lines finish first
+				in = nil
+				continue
 			}
-			if !busy { // ignore everything except Ctrl-D while executing a command
-				if len(cmd) > 0 {
-					go c.handleCmd(cmd, done)
-					busy = true
-				} else {
-					c.writePrompt()
+			if !busy {
+				busy = c.startCmd(cmd, done)
+			} else if c.plain {
+				queued = append(queued, cmd)
+			} else { // pass input through to the running command, see ReadLine
+				select {
+				case c.stdin <- cmd:
+				default:
+					c.log.Debug("input buffer is full, dropping line")
 				}
 			}
 		case exit := <-done:
-			if exit {
+			busy = false
+			if exit || (in == nil && len(queued) == 0) {
 				return
 			}
 			c.writePrompt()
-			busy = false
+			for !busy && len(queued) > 0 {
+				busy = c.startCmd(queued[0], done)
+				queued = queued[1:]
+			}
+			if !busy && in == nil {
+				return
+			}
 		}
 	}
 }
@@ -538,10 +955,19 @@ func (c *Client) handle() {
 // Server contains all values needed to run the server. Use NewServer to create
 // and Run to actually run the server.
 type Server struct {
-	ln       net.Listener
-	prompt   string
-	commands CmdList
-	userdata interface{}
+	ln         net.Listener
+	prompt     string
+	commands   CmdList
+	userdata   interface{}
+	log        *slog.Logger
+	auditor    Auditor
+	recordDir  string
+	paging     bool
+	machine    bool
+	socketPath string
+	sensitive  map[string][]int
+
+	trustedProxies []*net.IPNet
 }
 
 // NewServer creates a new telnet server struct. addr is the address to bind/listen to on and will be
@@ -559,6 +985,7 @@ func NewServer(addr, prompt string, commands CmdList, userdata interface{}) (s *
 }
 
 // NewServerFromListener does the same as NewServer takes a net listener except for an address.
+// ln may be nil for servers which only handle connections using ServeConn or RunStdio.
 func NewServerFromListener(ln net.Listener, prompt string, commands CmdList, userdata interface{}) (s *Server, err error) {
 	s = &Server{}
 	s.prompt = prompt
@@ -568,19 +995,30 @@ func NewServerFromListener(ln net.Listener, prompt string, commands CmdList, use
 	return
 }
 
-// Run opens the server socket and runs the telnet server which spawns go routines for every
-// connecting client. This function takes 2 optional parameters.
-// Parameter who implement the Greeter interface will be passed to clients as greet function.
-// These functions will be called before the first command prompt is shown. If the greeter function
-// returns true the connection will be closed after it's completion. In this case the user won't be able
-// to send any commands but might abort the running greet command using CTRl-C.
-// If the parameter is a normal command function it will be used as a default command which will be called
-// if the user entered an unknown command.
-func (s *Server) Run(params ...interface{}) error {
-	tl.Println("listening on", s.ln.Addr().String())
+// SetLogger sets the logger used by the server and all its client sessions.
+// Every message concerning a client session carries the session id and the
+// remote address of the client. Setting the logger to nil restores the default
+// behaviour, see the package documentation for details.
+func (s *Server) SetLogger(l *slog.Logger) {
+	s.log = l
+}
 
-	var greeter Greeter
-	var dflt Cmd
+// Logger returns the logger used by the server, see SetLogger. It may be
+// used by transports and commands to log messages the same way the server
+// does.
+func (s *Server) Logger() *slog.Logger {
+	return s.logger()
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.log == nil {
+		return defaultLogger
+	}
+	return s.log
+}
+
+// parseParams parses the optional parameters of Run and ServeConn
+func parseParams(params []interface{}) (greeter Greeter, dflt Cmd, setup func(c *Client)) {
 	for i, param := range params {
 		switch param.(type) {
 		case Greeter:
@@ -598,19 +1036,137 @@ func (s *Server) Run(params ...interface{}) error {
 				panic(fmt.Sprintf("telgo.run() invalid parameter(%d): default command may be supplied only once", i))
 			}
 			dflt = param.(Cmd)
+		case func(c *Client):
+			if prev, next := setup, param.(func(c *Client)); prev != nil {
+				setup = func(c *Client) {
+					prev(c)
+					next(c)
+				}
+			} else {
+				setup = next
+			}
 		default:
 			panic(fmt.Sprintf("telgo.run() invalid parameter(%d): type %T is not supported", i, param))
 		}
 	}
+	return
+}
 
+// Run opens the server socket and runs the telnet server which spawns go routines for every
+// connecting client. This function takes 3 kinds of optional parameters.
+// Parameter who implement the Greeter interface will be passed to clients as greet function.
+// These functions will be called before the first command prompt is shown. If the greeter function
+// returns true the connection will be closed after it's completion. In this case the user won't be able
+// to send any commands but might abort the running greet command using CTRl-C.
+// If the parameter is a normal command function it will be used as a default command which will be called
+// if the user entered an unknown command.
+// Functions of type func(*Client) will be called in order for every new client before the session starts.
+// They may be used to set up the client, for example to set Identity or UserData.
+func (s *Server) Run(params ...interface{}) error {
+	if s.ln == nil {
+		return errors.New("telgo: server has no listener")
+	}
+	s.logger().Info("listening", "addr", s.ln.Addr().String())
+
+	greeter, dflt, setup := parseParams(params)
 	for {
 		conn, err := s.ln.Accept()
 		if err != nil {
-			tl.Println("Accept() Error:", err)
+			s.logger().Error("Accept() error", "error", err)
 			return err
 		}
 
-		c := newClient(conn, s.prompt, greeter, &s.commands, dflt, s.userdata)
-		go c.handle()
+		go s.accept(conn, greeter, dflt, setup)
+	}
+}
+
+func (s *Server) accept(conn net.Conn, greeter Greeter, dflt Cmd, setup func(c *Client)) {
+	if s.isTrustedProxy(conn.RemoteAddr()) {
+		pc, err := newProxyConn(conn)
+		if err != nil {
+			s.logger().Warn("PROXY protocol error", "proxy", conn.RemoteAddr().String(), "error", err)
+			conn.Close()
+			return
+		}
+		conn = pc
+	}
+	c := newClient(conn, s, greeter, dflt)
+	if setup != nil {
+		setup(c)
+	}
+	c.handle()
+}
+
+// ServeConn runs a single client session on an already established
+// connection and returns when the session is over. The connection will be
+// closed. The optional parameters are the same as for Run.
+func (s *Server) ServeConn(conn net.Conn, params ...interface{}) {
+	greeter, dflt, setup := parseParams(params)
+	c := newClient(conn, s, greeter, dflt)
+	if setup != nil {
+		setup(c)
+	}
+	c.handle()
+}
+
+// ExecConn runs the single command line on an already established
+// connection and returns the exit status of the command, see StatusOK,
+// StatusError and StatusCancelled. There is neither a prompt nor a greeter,
+// input sent by the client is available to the command using ReadLine which
+// returns false once the input has ended. The command is canceled if the
+// user hits Ctrl-C or Ctrl-D or if the client goes away. The end of the
+// stream of a StreamConn only ends the input, the command is canceled once
+// the StreamConn gets closed. The
+// connection will be closed. The optional parameters are the same as for Run
+// except that a greeter will be ignored.
+func (s *Server) ExecConn(conn net.Conn, line string, params ...interface{}) int {
+	_, dflt, setup := parseParams(params)
+	c := newClient(conn, s, nil, dflt)
+	if setup != nil {
+		setup(c)
+	}
+	return c.exec(line)
+}
+
+func (c *Client) exec(line string) int {
+	defer func() {
+		c.log.Info("client disconnected", "duration", time.Since(c.started))
+	}()
+	defer c.Conn.Close()
+	defer c.rec.close()
+
+	in := make(chan string)
+	go c.recv(in)
+	go c.send()
+	defer func() {
+		close(c.quitSend)
+		<-c.sendDone
+	}()
+	go func() {
+		for line := range in {
+			select {
+			case c.stdin <- line:
+			default:
+				c.log.Debug("input buffer is full, dropping line")
+			}
+		}
+		close(c.inputClosed)
+		if sc, ok := c.Conn.(*StreamConn); ok && !c.gotEOT {
+			<-sc.done // the end of a stream only ends the input
+		}
+		c.cancel()
+	}()
+
+	cmdslice, err := splitCmdArguments(line)
+	if err != nil {
+		c.log.Warn("can't parse command", "error", err)
+		c.Sayln("can't parse command: %s", err)
+		c.audit(line, nil, time.Now(), false, err)
+		return StatusError
+	}
+	if len(cmdslice) == 0 || cmdslice[0] == "" {
+		return StatusOK
 	}
+	_, err = c.runCmd(line, cmdslice)
+	return c.exitStatus(err)
 }
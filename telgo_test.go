@@ -0,0 +1,187 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/spreadspace/telgo"
+	"github.com/spreadspace/telgo/telgotest"
+)
+
+var sessionCmds = telgo.CmdList{
+	"size": func(c *telgo.Client, args []string) bool {
+		w, h := c.WindowSize()
+		c.Sayln("%dx%d", w, h)
+		return false
+	},
+	"ttype": func(c *telgo.Client, args []string) bool {
+		c.Sayln("%s", c.TerminalType())
+		return false
+	},
+	"remote": func(c *telgo.Client, args []string) bool {
+		c.Sayln("%s", c.Conn.RemoteAddr())
+		return false
+	},
+}
+
+func TestWindowSize(t *testing.T) {
+	h := telgotest.New(t, "> ", sessionCmds, nil)
+	s := h.Connect()
+	s.ExpectPrompt()
+	if out := s.Run("size"); out != "80x24\n" {
+		t.Errorf("expected the default window size, got %q", out)
+	}
+	for _, size := range []struct {
+		width, height int
+		want          string
+	}{
+		{132, 43, "132x43\n"},
+		{255, 255, "255x255\n"}, // the IAC bytes are escaped
+		{65535, 1, "65535x1\n"},
+	} {
+		s.SetWindowSize(size.width, size.height)
+		if out := s.Run("size"); out != size.want {
+			t.Errorf("expected %q, got %q", size.want, out)
+		}
+	}
+}
+
+func TestTerminalType(t *testing.T) {
+	h := telgotest.New(t, "> ", sessionCmds, nil)
+	s := h.Connect()
+	s.SetTerminalType("xterm-256color")
+	s.ExpectPrompt()
+	if out := s.Run("ttype"); out != "xterm-256color\n" {
+		t.Errorf("unexpected terminal type: %q", out)
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("can't listen on loopback: %v", err)
+	}
+	srv, err := telgo.NewServerFromListener(ln, "> ", sessionCmds, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.SetProxyProtocol("127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer ln.Close()
+
+	v2 := []byte("\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c")
+	v2 = append(v2, 192, 0, 2, 7, 127, 0, 0, 1, 0x12, 0x67, 0x00, 0x17)
+	for _, tt := range []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"v1", []byte("PROXY TCP4 192.0.2.1 127.0.0.1 4711 23\r\n"), "192.0.2.1:4711\n"},
+		{"v2", v2, "192.0.2.7:4711\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := conn.Write(tt.header); err != nil {
+				t.Fatal(err)
+			}
+			s := telgotest.NewSession(t, conn, "> ")
+			defer s.Close()
+			s.ExpectPrompt()
+			if out := s.Run("remote"); out != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, out)
+			}
+		})
+	}
+}
+
+func TestQuoteArgument(t *testing.T) {
+	for _, tt := range []struct {
+		arg, quoted string
+	}{
+		{"plain", "plain"},
+		{"", `""`},
+		{"two words", `"two words"`},
+		{`a"b`, `"a\"b"`},
+		{`back\slash`, `"back\\slash"`},
+		{"tab\there", "\"tab\there\""},
+		{"new\nline", "\"new\nline\""},
+		{"cr\r\vff\f", "\"cr\r\vff\f\""},
+		{"no\u00a0break", "\"no\u00a0break\""},
+		{"ünïcode", "ünïcode"},
+	} {
+		if q := telgo.QuoteArgument(tt.arg); q != tt.quoted {
+			t.Errorf("%q: expected %s, got %s", tt.arg, tt.quoted, q)
+		}
+		args, err := telgo.SplitArguments("cmd " + telgo.QuoteArgument(tt.arg))
+		if err != nil {
+			t.Errorf("%q: can't split quoted argument: %v", tt.arg, err)
+			continue
+		}
+		if len(args) != 2 || args[1] != tt.arg {
+			t.Errorf("%q: round trip failed: %q", tt.arg, args)
+		}
+	}
+}
+
+type spinnerGreeter struct{}
+
+func (spinnerGreeter) Exec(c *telgo.Client, args []string) bool {
+	c.Spinner("loading") // finished implicitly when the greeter returns
+	return false
+}
+
+func TestWidgetsFinish(t *testing.T) {
+	cmds := telgo.CmdList{
+		"spin": func(c *telgo.Client, args []string) bool {
+			c.Spinner("working")
+			<-c.Context().Done()
+			return false
+		},
+	}
+	h := telgotest.New(t, "> ", cmds, nil, spinnerGreeter{})
+	s := h.Connect()
+	if out := s.ExpectPrompt(); !strings.Contains(out, "loading done.") || strings.Contains(out, "aborted.") {
+		t.Errorf("the spinner of the greeter didn't finish normally: %q", out)
+	}
+	s.Type("spin")
+	s.Expect("working")
+	s.CtrlC()
+	if out := s.ExpectPrompt(); !strings.Contains(out, "working aborted.") {
+		t.Errorf("the spinner of the canceled command wasn't aborted: %q", out)
+	}
+}
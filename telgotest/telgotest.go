@@ -0,0 +1,520 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+// Package telgotest provides utilities to test telgo commands without any
+// network connections or telnet clients. A Harness runs a telgo server on an
+// in-memory listener and hands out sessions which can type lines, send
+// Ctrl-C, Ctrl-D or arbitrary telnet commands and wait for output:
+//
+//	func TestEcho(t *testing.T) {
+//		h := telgotest.New(t, "> ", cmds, nil)
+//		s := h.Connect()
+//		s.ExpectPrompt()
+//		if out := s.Run("echo hello"); out != "hello\n" {
+//			t.Errorf("unexpected output: %q", out)
+//		}
+//		s.Golden("testdata/echo.golden")
+//	}
+//
+// Telnet commands sent by the server are removed from the output and
+// new-lines are converted to "\n". Every session records a transcript of
+// everything received and typed which can be compared to a golden file. Set
+// the environment variable TELGOTEST_UPDATE to rewrite the golden files
+// instead.
+package telgotest
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spreadspace/telgo"
+)
+
+// Telnet protocol bytes which may be useful together with Session.SendIAC.
+const (
+	IP   = byte(244)
+	SB   = byte(250)
+	WILL = byte(251)
+	WONT = byte(252)
+	DO   = byte(253)
+	DONT = byte(254)
+	IAC  = byte(255)
+	SE   = byte(240)
+
+	NAWS  = byte(31)
+	TTYPE = byte(24)
+)
+
+const (
+	ttypeIS   = byte(0)
+	ttypeSEND = byte(1)
+)
+
+// DefaultTimeout is the time the Expect functions of a new session wait
+// for output before failing the test.
+const DefaultTimeout = 5 * time.Second
+
+// UpdateEnv is the name of the environment variable which makes Golden
+// write the golden files instead of comparing them.
+const UpdateEnv = "TELGOTEST_UPDATE"
+
+// Harness runs a telgo server for a test. The server is stopped when the
+// test finishes.
+type Harness struct {
+	Server *telgo.Server
+	tb     testing.TB
+	ln     *Listener
+	prompt string
+}
+
+// New creates a telgo server for the test tb and runs it on an in-memory
+// listener. The parameters are the same as for telgo.NewServer and
+// telgo.Server.Run. Use the Server field to configure the server further
+// before the first session connects.
+func New(tb testing.TB, prompt string, commands telgo.CmdList, userdata interface{}, params ...interface{}) *Harness {
+	tb.Helper()
+	h := &Harness{tb: tb, ln: NewListener(), prompt: prompt}
+	var err error
+	if h.Server, err = telgo.NewServerFromListener(h.ln, prompt, commands, userdata); err != nil {
+		tb.Fatalf("telgotest: can't create server: %v", err)
+	}
+	go h.Server.Run(params...)
+	tb.Cleanup(func() { h.ln.Close() })
+	return h
+}
+
+// Connect opens a new session. The session gets closed when the test
+// finishes.
+func (h *Harness) Connect() *Session {
+	h.tb.Helper()
+	conn, err := h.ln.Dial()
+	if err != nil {
+		h.tb.Fatalf("telgotest: can't connect: %v", err)
+	}
+	s := NewSession(h.tb, conn, h.prompt)
+	h.tb.Cleanup(func() { s.Close() })
+	return s
+}
+
+// Session is a client connection to a telgo server.
+type Session struct {
+	tb      testing.TB
+	conn    net.Conn
+	prompt  string
+	timeout time.Duration
+	out     chan []byte
+	closed  chan bool
+
+	mu         sync.Mutex
+	pending    []byte
+	transcript []byte
+	notify     chan bool
+	eof        bool
+	local      map[byte]bool
+	ttype      string
+	ttypeSent  bool
+}
+
+// NewSession creates a session using an established connection to a telgo
+// server. prompt is the prompt of the server. Most tests will use
+// Harness.Connect instead.
+func NewSession(tb testing.TB, conn net.Conn, prompt string) *Session {
+	s := &Session{tb: tb, conn: conn, prompt: prompt, timeout: DefaultTimeout}
+	s.out = make(chan []byte, 256)
+	s.closed = make(chan bool)
+	s.notify = make(chan bool)
+	s.local = make(map[byte]bool)
+	go s.recv()
+	go s.send()
+	return s
+}
+
+// SetTimeout sets the time the Expect functions wait for output.
+func (s *Session) SetTimeout(d time.Duration) {
+	s.timeout = d
+}
+
+// Close closes the connection to the server.
+func (s *Session) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+		s.conn.Close()
+	}
+}
+
+// send writes the queued data to the connection. net.Pipe is synchronous so
+// writes can't be done by the receiver, otherwise both sides might block
+// each other.
+func (s *Session) send() {
+	for {
+		select {
+		case data := <-s.out:
+			if _, err := s.conn.Write(data); err != nil {
+				return
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+func (s *Session) queue(data []byte) {
+	select {
+	case s.out <- data:
+	case <-s.closed:
+	}
+}
+
+func (s *Session) recv() {
+	const (
+		stData = iota
+		stIAC
+		stOpt
+		stSB
+		stSBIAC
+	)
+	state := stData
+	var cmd byte
+	var sb []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := s.conn.Read(buf)
+		s.mu.Lock()
+		for _, b := range buf[:n] {
+			switch state {
+			case stData:
+				if b == IAC {
+					state = stIAC
+				} else {
+					s.pending = append(s.pending, b)
+					s.transcript = append(s.transcript, b)
+				}
+			case stIAC:
+				switch b {
+				case IAC:
+					s.pending = append(s.pending, b)
+					s.transcript = append(s.transcript, b)
+					state = stData
+				case WILL, WONT, DO, DONT:
+					cmd, state = b, stOpt
+				case SB:
+					sb, state = nil, stSB
+				default:
+					state = stData
+				}
+			case stOpt:
+				s.negotiate(cmd, b)
+				state = stData
+			case stSB:
+				if b == IAC {
+					state = stSBIAC
+				} else {
+					sb = append(sb, b)
+				}
+			case stSBIAC:
+				switch b {
+				case SE:
+					s.subnegotiation(sb)
+					state = stData
+				case IAC:
+					sb = append(sb, b)
+					state = stSB
+				default:
+					state = stSB
+				}
+			}
+		}
+		if err != nil {
+			s.eof = true
+		}
+		close(s.notify)
+		s.notify = make(chan bool)
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// negotiate must be called with s.mu held. Only the options enabled using
+// SetWindowSize or SetTerminalType are accepted.
+func (s *Session) negotiate(cmd, opt byte) {
+	switch cmd {
+	case DO:
+		if !s.local[opt] {
+			s.queue([]byte{IAC, WONT, opt})
+		}
+	case DONT:
+		if s.local[opt] {
+			s.local[opt] = false
+			s.queue([]byte{IAC, WONT, opt})
+		}
+	case WILL:
+		s.queue([]byte{IAC, DONT, opt})
+	}
+}
+
+// subnegotiation must be called with s.mu held.
+func (s *Session) subnegotiation(params []byte) {
+	if len(params) == 2 && params[0] == TTYPE && params[1] == ttypeSEND && s.local[TTYPE] {
+		reply := append([]byte{IAC, SB, TTYPE, ttypeIS}, s.ttype...)
+		s.queue(append(reply, IAC, SE))
+		s.ttypeSent = true
+	}
+}
+
+func escapeIAC(data []byte) []byte {
+	return bytes.Replace(data, []byte{IAC}, []byte{IAC, IAC}, -1)
+}
+
+func (s *Session) record(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transcript = append(s.transcript, text...)
+}
+
+// Type sends line to the server as if the user typed it and hit enter.
+func (s *Session) Type(line string) {
+	s.record(line + "\n")
+	s.queue(append(escapeIAC([]byte(line)), '\r', '\n'))
+}
+
+// CtrlC sends the telnet interrupt process command like telnet clients do
+// when the user hits Ctrl-C. The server ignores interrupts which arrive
+// before the command has been started, so wait for some output of the
+// command before sending it.
+func (s *Session) CtrlC() {
+	s.record("^C")
+	s.queue([]byte{IAC, IP})
+}
+
+// CtrlD sends the end of transmission character which closes the session.
+func (s *Session) CtrlD() {
+	s.record("^D")
+	s.queue([]byte{4})
+}
+
+// SendIAC sends the telnet command consisting of IAC followed by cmd.
+func (s *Session) SendIAC(cmd ...byte) {
+	s.queue(append([]byte{IAC}, cmd...))
+}
+
+// Send sends data to the server as is.
+func (s *Session) Send(data []byte) {
+	s.queue(append([]byte(nil), data...))
+}
+
+// SetWindowSize enables the NAWS option and reports the window size to the
+// server.
+func (s *Session) SetWindowSize(width, height int) {
+	s.mu.Lock()
+	if !s.local[NAWS] {
+		s.local[NAWS] = true
+		s.queue([]byte{IAC, WILL, NAWS})
+	}
+	s.mu.Unlock()
+	size := escapeIAC([]byte{byte(width >> 8), byte(width), byte(height >> 8), byte(height)})
+	s.queue(append(append([]byte{IAC, SB, NAWS}, size...), IAC, SE))
+}
+
+// SetTerminalType enables the TERMINAL-TYPE option and waits until the
+// server asked for the terminal type. Servers ask only once so changing the
+// terminal type later on has no effect.
+func (s *Session) SetTerminalType(ttype string) {
+	s.tb.Helper()
+	s.mu.Lock()
+	s.ttype = ttype
+	if s.local[TTYPE] {
+		s.mu.Unlock()
+		return
+	}
+	s.local[TTYPE] = true
+	s.queue([]byte{IAC, WILL, TTYPE})
+	s.mu.Unlock()
+
+	s.until("the server to ask for the terminal type", func() bool { return s.ttypeSent })
+}
+
+func normalize(data []byte) string {
+	return strings.Replace(string(data), "\r\n", "\n", -1)
+}
+
+// until waits until done returns true. done is called with s.mu held. The
+// test fails if the timeout expires or the connection gets closed before.
+func (s *Session) until(what string, done func() bool) {
+	s.tb.Helper()
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		ok, eof, notify := done(), s.eof, s.notify
+		pending := normalize(s.pending)
+		s.mu.Unlock()
+		if ok {
+			return
+		}
+		if eof {
+			s.tb.Fatalf("telgotest: connection closed while waiting for %s, got %q", what, pending)
+			return
+		}
+		select {
+		case <-notify:
+		case <-timer.C:
+			s.tb.Fatalf("telgotest: timeout while waiting for %s, got %q", what, pending)
+			return
+		}
+	}
+}
+
+// wait waits until match finds something inside the pending output. match
+// returns the index at which the match starts and its length or -1 if there
+// is no match. The output up to the match is returned and removed from the
+// pending output together with the match itself.
+func (s *Session) wait(what string, match func(pending string) (int, int)) (out string) {
+	s.tb.Helper()
+	s.until(what, func() bool {
+		pending := string(s.pending)
+		i, l := match(pending)
+		if i < 0 {
+			return false
+		}
+		s.pending = s.pending[i+l:]
+		out = normalize([]byte(pending[:i]))
+		return true
+	})
+	return out
+}
+
+// Expect waits until the server sent text and returns everything which was
+// received before it. text itself is consumed as well, the next Expect
+// starts right after it. Line endings inside text must be "\r\n". The test
+// fails if text isn't received within the timeout.
+func (s *Session) Expect(text string) string {
+	s.tb.Helper()
+	return s.wait("\""+text+"\"", func(pending string) (int, int) {
+		return strings.Index(pending, text), len(text)
+	})
+}
+
+// ExpectPrompt waits for the prompt and returns everything received before
+// it, which is the output of the last command.
+func (s *Session) ExpectPrompt() string {
+	s.tb.Helper()
+	return s.wait("the prompt", func(pending string) (int, int) {
+		return strings.Index(pending, s.prompt), len(s.prompt)
+	})
+}
+
+// ExpectClosed waits for the server to close the connection and returns
+// everything received before.
+func (s *Session) ExpectClosed() (out string) {
+	s.tb.Helper()
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		eof, notify := s.eof, s.notify
+		if eof {
+			out = normalize(s.pending)
+			s.pending = nil
+		}
+		s.mu.Unlock()
+		if eof {
+			return out
+		}
+		select {
+		case <-notify:
+		case <-timer.C:
+			s.tb.Fatalf("telgotest: timeout while waiting for the connection to be closed")
+			return ""
+		}
+	}
+}
+
+// Run types line and waits for the next prompt. It returns the output of
+// the command.
+func (s *Session) Run(line string) string {
+	s.tb.Helper()
+	s.Type(line)
+	return s.ExpectPrompt()
+}
+
+// Output returns everything received which hasn't been consumed by one of
+// the Expect functions yet, without waiting for anything.
+func (s *Session) Output() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := s.pending
+	s.pending = nil
+	return normalize(out)
+}
+
+// Transcript returns everything received from the server with the lines
+// typed into the session inserted where they were typed, which looks like
+// the session would look on the screen of a terminal. Ctrl-C and Ctrl-D are
+// shown as ^C and ^D.
+func (s *Session) Transcript() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return normalize(s.transcript)
+}
+
+// Golden compares the transcript of the session with the contents of the
+// file at path and fails the test if they differ. If the environment
+// variable TELGOTEST_UPDATE is set the file is written instead.
+func (s *Session) Golden(path string) {
+	s.tb.Helper()
+	transcript := s.Transcript()
+	if os.Getenv(UpdateEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			s.tb.Fatalf("telgotest: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(transcript), 0644); err != nil {
+			s.tb.Fatalf("telgotest: %v", err)
+		}
+		return
+	}
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		s.tb.Fatalf("telgotest: can't read golden file (set %s to create it): %v", UpdateEnv, err)
+	}
+	if string(golden) != transcript {
+		s.tb.Errorf("telgotest: transcript doesn't match %s\n--- got:\n%s\n--- want:\n%s", path, transcript, golden)
+	}
+}
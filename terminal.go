@@ -0,0 +1,144 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo
+
+import (
+	"strings"
+)
+
+// Capability describes features of the user's terminal. Output helpers use
+// them to degrade gracefully if the terminal doesn't support a feature.
+type Capability uint
+
+// These are the known terminal capabilities.
+const (
+	CapColor   Capability = 1 << iota // ANSI colors and text attributes
+	CapCursor                         // ANSI cursor movement and line erasing
+	CapUnicode                        // characters outside of ASCII
+)
+
+const (
+	capsAll = CapColor | CapCursor | CapUnicode
+)
+
+// list of terminal type prefixes and their capabilities, the first match wins
+var terminalCaps = []struct {
+	prefix string
+	caps   Capability
+}{
+	{"xterm", capsAll},
+	{"rxvt", capsAll},
+	{"screen", capsAll},
+	{"tmux", capsAll},
+	{"alacritty", capsAll},
+	{"kitty", capsAll},
+	{"konsole", capsAll},
+	{"gnome", capsAll},
+	{"putty", capsAll},
+	{"linux", CapColor | CapCursor},
+	{"cygwin", CapColor | CapCursor},
+	{"ansi", CapColor | CapCursor},
+	{"vt", CapCursor},
+	{"dumb", 0},
+}
+
+func terminalCapabilities(termType string) (caps Capability) {
+	t := strings.ToLower(termType)
+	for _, tc := range terminalCaps {
+		if strings.HasPrefix(t, tc.prefix) {
+			caps = tc.caps
+			break
+		}
+	}
+	if strings.Contains(t, "color") {
+		caps |= CapColor
+	}
+	return
+}
+
+// TerminalType returns the type of the user's terminal as reported by the
+// client using the telnet option TERMINAL-TYPE (RFC 1091), for example
+// xterm or vt100. It returns the empty string as long as the terminal type
+// is unknown.
+func (c *Client) TerminalType() string {
+	c.termMu.Lock()
+	defer c.termMu.Unlock()
+	return c.termType
+}
+
+// SetTerminalType sets the type of the user's terminal and derives the
+// capabilities from it. This is done automatically for telnet clients which
+// support TERMINAL-TYPE but may be used by other transports.
+func (c *Client) SetTerminalType(termType string) {
+	caps := terminalCapabilities(termType)
+	c.termMu.Lock()
+	c.termType = termType
+	c.caps = caps
+	c.termMu.Unlock()
+	c.log.Debug("terminal type changed", "type", termType, "capabilities", caps)
+}
+
+// Capabilities returns the set of capabilities of the user's terminal. As
+// long as the terminal type is unknown no capabilities are reported.
+func (c *Client) Capabilities() Capability {
+	c.termMu.Lock()
+	defer c.termMu.Unlock()
+	return c.caps
+}
+
+// SetCapabilities overrides the capabilities derived from the terminal type.
+func (c *Client) SetCapabilities(caps Capability) {
+	c.termMu.Lock()
+	defer c.termMu.Unlock()
+	c.caps = caps
+}
+
+// Has returns true if the user's terminal has all of the capabilities in caps.
+func (c *Client) Has(caps Capability) bool {
+	return c.Capabilities()&caps == caps
+}
+
+// String returns a human readable list of the capabilities.
+func (caps Capability) String() string {
+	var names []string
+	if caps&CapColor != 0 {
+		names = append(names, "color")
+	}
+	if caps&CapCursor != 0 {
+		names = append(names, "cursor")
+	}
+	if caps&CapUnicode != 0 {
+		names = append(names, "unicode")
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
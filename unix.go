@@ -0,0 +1,176 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package telgo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// PeerCred contains the credentials of the process at the other end of a
+// unix socket connection as reported by the operating system.
+type PeerCred struct {
+	UID int
+	GID int
+	PID int
+}
+
+// NewUnixServer creates a telnet server listening on the unix socket at
+// path. If mode is not 0 the permissions of the socket are set to mode
+// before it becomes reachable at path, the directory containing path must be
+// writable for this to work. A stale socket left over by a server which
+// didn't shut down properly is removed, but if another server is still
+// accepting connections on path or path is not a socket NewUnixServer fails.
+// Clients connecting to the server get the credentials of their process
+// assigned, see Client.PeerCred. The remaining parameters are the same as for
+// NewServer.
+func NewUnixServer(path string, mode os.FileMode, prompt string, commands CmdList, userdata interface{}) (s *Server, err error) {
+	if err = removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := listenUnix(path, mode)
+	if err != nil {
+		return nil, err
+	}
+	s, err = NewServerFromListener(ln, prompt, commands, userdata)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	s.socketPath = path
+	return s, nil
+}
+
+// unixListener is a listener whose socket has been moved to path after it
+// was created. The socket is removed when the listener is closed.
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+// listenUnix listens on the unix socket at path. If mode is not 0 the socket
+// is created inside a temporary directory only the owner can access and is
+// moved to path once its permissions have been set to mode. This way the
+// socket is never reachable with any other permissions.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if mode == 0 {
+		return net.Listen("unix", path)
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".telgo-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(dir)
+	tmp := filepath.Join(dir, "socket")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	ln.SetUnlinkOnClose(false)
+	if err = os.Chmod(tmp, mode); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		ln.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	return &unixListener{UnixListener: ln, path: path}, nil
+}
+
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another server", path)
+	}
+	return os.Remove(path)
+}
+
+// SetSocketOwner changes the owner and group of the socket of a server
+// created by NewUnixServer. A uid or gid of -1 leaves the value unchanged.
+func (s *Server) SetSocketOwner(uid, gid int) error {
+	if s.socketPath == "" {
+		return errors.New("server is not listening on a unix socket")
+	}
+	return os.Chown(s.socketPath, uid, gid)
+}
+
+// PeerCred returns the credentials of the process connected to the server
+// via a unix socket. It returns nil for other connections or if the
+// operating system doesn't support this.
+func (c *Client) PeerCred() *PeerCred {
+	return c.peer
+}
+
+// initPeerCred reads the peer credentials of unix socket connections and
+// uses the name of the user as identity.
+func (c *Client) initPeerCred() {
+	uc, ok := c.Conn.(*net.UnixConn)
+	if !ok {
+		return
+	}
+	cred, err := peerCred(uc)
+	if err != nil {
+		c.log.Warn("can't read peer credentials", "error", err)
+		return
+	}
+	c.peer = cred
+	c.Identity = "uid:" + strconv.Itoa(cred.UID)
+	if u, err := user.LookupId(strconv.Itoa(cred.UID)); err == nil {
+		c.Identity = u.Username
+	}
+	c.log = c.log.With("uid", cred.UID, "gid", cred.GID, "pid", cred.PID)
+}
@@ -0,0 +1,119 @@
+//
+//  telgo
+//
+// Copyright (c) 2015 Christian Pointner <equinox@spreadspace.org>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//     * Redistributions of source code must retain the above copyright
+//       notice, this list of conditions and the following disclaimer.
+//     * Redistributions in binary form must reproduce the above copyright
+//       notice, this list of conditions and the following disclaimer in the
+//       documentation and/or other materials provided with the distribution.
+//     * Neither the name of telgo nor the names of its contributors may be
+//       used to endorse or promote products derived from this software without
+//       specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+// Package web makes telgo servers available to web browsers. Handler runs a
+// client session over a WebSocket connection and Page serves a minimal
+// terminal emulator which connects to it:
+//
+//	http.Handle("/console/ws", web.Handler(s))
+//	http.Handle("/console/", web.Page("/console/ws"))
+//
+// The WebSocket carries the same telnet protocol as a TCP connection so the
+// sessions behave exactly like telnet sessions.
+package web
+
+import (
+	_ "embed" // for the terminal page
+	"errors"
+	"html/template"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/spreadspace/telgo"
+	"golang.org/x/net/websocket"
+)
+
+//go:embed terminal.html
+var terminalHTML string
+
+var pageTmpl = template.Must(template.New("terminal").Parse(terminalHTML))
+
+type remoteAddr string
+
+func (a remoteAddr) Network() string { return "tcp" }
+func (a remoteAddr) String() string  { return string(a) }
+
+// conn is a WebSocket connection which reports the address of the HTTP
+// client as remote address instead of the origin of the page.
+type conn struct {
+	*websocket.Conn
+	remote net.Addr
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// checkOrigin makes sure browsers only connect from pages served by the
+// same host. Clients which don't send an Origin header are accepted.
+func checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	o, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if o == nil || !strings.EqualFold(o.Host, r.Host) {
+		return errors.New("cross origin request")
+	}
+	config.Origin = o
+	return nil
+}
+
+// Handler returns an http.Handler which upgrades requests to WebSocket and
+// runs a client session of s on every connection. The optional parameters
+// are the same as for telgo.Server.Run. Browsers may only connect from
+// pages served by the same host. The remote address of the sessions is the
+// address of the HTTP client, if there are proxies in between this is the
+// address of the last proxy.
+func Handler(s *telgo.Server, params ...interface{}) http.Handler {
+	return websocket.Server{
+		Handshake: checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			ws.PayloadType = websocket.BinaryFrame
+			s.ServeConn(&conn{Conn: ws, remote: remoteAddr(ws.Request().RemoteAddr)}, params...)
+		},
+	}
+}
+
+// Page returns an http.Handler serving a page with a minimal terminal
+// emulator which connects to the WebSocket at path, see Handler. The
+// terminal supports line editing with a history, Ctrl-C and Ctrl-D. Escape
+// sequences like colors are removed from the output.
+func Page(path string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := pageTmpl.Execute(w, struct{ Path string }{path}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}